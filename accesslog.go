@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access log formats accepted by -access-log-format.
+const (
+	accessText = "text"
+	accessCLF  = "clf"
+	accessJSON = "json"
+)
+
+var (
+	accessLogFormat = accessText
+	// accessSampling is the fraction of requests logged per path; "default"
+	// covers every other path. Failed requests are always logged.
+	accessSampling = sampleRates{"default": 1}
+)
+
+// sampleRates is a flag.Value holding rates per path, written as
+// "/askpass/prompts=0.1,/healthz=0".
+type sampleRates map[string]float64
+
+func (s sampleRates) String() string {
+	paths := make([]string, 0, len(s))
+	for path := range s {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	parts := make([]string, len(paths))
+	for i, path := range paths {
+		parts[i] = path + "=" + strconv.FormatFloat(s[path], 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s sampleRates) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		eq := strings.IndexByte(part, '=')
+		if eq <= 0 {
+			return fmt.Errorf("%q is not of the form path=rate", part)
+		}
+		rate, err := strconv.ParseFloat(part[eq+1:], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("%q: rate must be between 0 and 1", part)
+		}
+		s[strings.TrimSpace(part[:eq])] = rate
+	}
+	return nil
+}
+
+func (s sampleRates) sampled(path string) bool {
+	rate, ok := s[path]
+	if !ok {
+		rate = s["default"]
+	}
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// responseRecorder remembers the status and size of the response passing
+// through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+type accessEntry struct {
+	Time       time.Time `json:"Time"`
+	RequestID  string    `json:"RequestID"`
+	RemoteAddr string    `json:"RemoteAddr"`
+	Method     string    `json:"Method"`
+	Path       string    `json:"Path"`
+	Proto      string    `json:"Proto"`
+	Status     int       `json:"Status"`
+	Bytes      int64     `json:"Bytes"`
+	DurationMs float64   `json:"DurationMs"`
+}
+
+// writeAccessEntry logs e in accessLogFormat. CLF lines carry the duration
+// in seconds and the request ID after the standard fields.
+func writeAccessEntry(logger *leveledLogger, e accessEntry) {
+	switch accessLogFormat {
+	case accessJSON:
+		js, _ := json.Marshal(e)
+		logger.writeRaw(append(js, '\n'))
+	case accessCLF:
+		host, _, err := net.SplitHostPort(e.RemoteAddr)
+		if err != nil {
+			host = e.RemoteAddr
+		}
+		logger.writeRaw([]byte(fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d %.3f %s\n",
+			host, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Path, e.Proto,
+			e.Status, e.Bytes, e.DurationMs/1000, e.RequestID)))
+	default:
+		logger.Info("request", "id", e.RequestID, "method", e.Method, "path", e.Path, "remote", e.RemoteAddr,
+			"status", e.Status, "bytes", e.Bytes, "duration", time.Duration(e.DurationMs*float64(time.Millisecond)).String())
+	}
+}
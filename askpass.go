@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// git and ssh run this same executable as their GIT_ASKPASS/SSH_ASKPASS
+// helper. The helper finds its way back to the server through these
+// variables, which are only ever set in the environment of git children.
+const (
+	askpassURLEnv   = "GITIFY_ASKPASS_URL"
+	askpassTokenEnv = "GITIFY_ASKPASS_TOKEN"
+	askpassHeader   = "X-Askpass-Token"
+)
+
+var (
+	askpassTimeout time.Duration
+	askpassURL     string
+	askpassToken   string
+	askpassExe     string
+	// askpassOrigins, given with -askpass-origin, are the only origins
+	// allowed to read and answer prompts. Without any, every browser
+	// extension is allowed and web pages are not.
+	askpassOrigins stringList
+)
+
+// extensionSchemes are the origins browsers give their extensions.
+var extensionSchemes = []string{"chrome-extension://", "moz-extension://", "safari-web-extension://"}
+
+// promptOriginAllowed reports whether a request from origin may see and
+// answer prompts. Browsers always send Origin from a web page, so a request
+// without one comes from a local program rather than a site the user visits.
+func promptOriginAllowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	if len(askpassOrigins) > 0 {
+		for _, allowed := range askpassOrigins {
+			if origin == allowed {
+				return true
+			}
+		}
+		return false
+	}
+	for _, scheme := range extensionSchemes {
+		if strings.HasPrefix(origin, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// setupPromptResponse is setupResponse for the prompt endpoints: it answers
+// 403 to any other origin and reports whether the request may go on.
+func setupPromptResponse(w *http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !promptOriginAllowed(origin) {
+		http.Error(*w, "origin not allowed", http.StatusForbidden)
+		return false
+	}
+	setupResponse(w, r)
+	if origin != "" {
+		(*w).Header().Set("Access-Control-Allow-Origin", origin)
+		(*w).Header().Add("Vary", "Origin")
+	}
+	return true
+}
+
+type askpassPrompt struct {
+	ID      string    `json:"ID"`
+	Prompt  string    `json:"Prompt"`
+	Secret  bool      `json:"Secret"`
+	Created time.Time `json:"Created"`
+	answer  chan askpassAnswer
+}
+
+type askpassAnswer struct {
+	ID     string `json:"ID"`
+	Answer string `json:"Answer"`
+	Cancel bool   `json:"Cancel"`
+}
+
+// askpassBroker holds the prompts git is currently blocked on until the
+// extension answers them.
+type askpassBroker struct {
+	mu      sync.Mutex
+	seq     int
+	pending map[string]*askpassPrompt
+}
+
+var prompts = &askpassBroker{pending: map[string]*askpassPrompt{}}
+
+func (b *askpassBroker) open(text string) *askpassPrompt {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	lower := strings.ToLower(text)
+	p := &askpassPrompt{
+		ID:      strconv.Itoa(b.seq),
+		Prompt:  text,
+		Secret:  strings.Contains(lower, "password") || strings.Contains(lower, "passphrase") || strings.Contains(lower, "token"),
+		Created: time.Now(),
+		answer:  make(chan askpassAnswer, 1),
+	}
+	b.pending[p.ID] = p
+	return p
+}
+
+func (b *askpassBroker) close(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pending, id)
+}
+
+func (b *askpassBroker) list() []*askpassPrompt {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := []*askpassPrompt{}
+	for _, p := range b.pending {
+		list = append(list, p)
+	}
+	return list
+}
+
+// deliver hands an answer to the waiting prompt. It reports false when the
+// prompt is unknown or was already answered.
+func (b *askpassBroker) deliver(a askpassAnswer) bool {
+	b.mu.Lock()
+	p, ok := b.pending[a.ID]
+	delete(b.pending, a.ID)
+	b.mu.Unlock()
+	if !ok {
+		return false
+	}
+	p.answer <- a
+	return true
+}
+
+// setupAskpass prepares the credentials bridge for a server listening on
+// addr. Without it git still runs non-interactively and simply fails when
+// it needs credentials.
+func setupAskpass(addr string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	askpassExe = exe
+	askpassURL = "http://" + net.JoinHostPort(host, port) + "/askpass/request"
+	askpassToken = hex.EncodeToString(secret)
+	return nil
+}
+
+// gitEnv is added to the environment of every git child so that it never
+// waits on a terminal that does not exist.
+func gitEnv() []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if askpassExe == "" {
+		return env
+	}
+	env = append(env,
+		"GIT_ASKPASS="+askpassExe,
+		"SSH_ASKPASS="+askpassExe,
+		"SSH_ASKPASS_REQUIRE=force",
+		askpassURLEnv+"="+askpassURL,
+		askpassTokenEnv+"="+askpassToken,
+	)
+	if os.Getenv("DISPLAY") == "" {
+		// Older OpenSSH only consults SSH_ASKPASS when DISPLAY is set.
+		env = append(env, "DISPLAY=:0")
+	}
+	return env
+}
+
+// isAskpassHelper reports whether this process was started by git or ssh
+// as a credentials helper rather than by the user.
+func isAskpassHelper() bool {
+	return os.Getenv(askpassURLEnv) != "" && os.Getenv(askpassTokenEnv) != ""
+}
+
+// askpassMain relays the prompt in args to the server, prints the answer
+// for git to read and returns the process exit code.
+func askpassMain(args []string) int {
+	body, _ := json.Marshal(map[string]string{"Prompt": strings.Join(args, " ")})
+	req, err := http.NewRequest("POST", os.Getenv(askpassURLEnv), bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "askpass:", err)
+		return 1
+	}
+	req.Header.Set(askpassHeader, os.Getenv(askpassTokenEnv))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "askpass:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "askpass: no answer:", resp.Status)
+		return 1
+	}
+	var a askpassAnswer
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		fmt.Fprintln(os.Stderr, "askpass:", err)
+		return 1
+	}
+	fmt.Println(a.Answer)
+	return 0
+}
+
+// askpassRequest is called by the helper. It parks the prompt until the
+// extension answers it, the user cancels, or askpassTimeout passes.
+func askpassRequest() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(askpassHeader)
+		if askpassToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(askpassToken)) != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		var msg struct {
+			Prompt string `json:"Prompt"`
+		}
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+
+		p := prompts.open(msg.Prompt)
+		defer prompts.close(p.ID)
+		logger.Info("git is waiting for an answer", "prompt", p.ID)
+
+		timer := time.NewTimer(askpassTimeout)
+		defer timer.Stop()
+		select {
+		case a := <-p.answer:
+			if a.Cancel {
+				http.Error(w, "prompt cancelled", http.StatusGone)
+				return
+			}
+			writeJSON(w, http.StatusOK, a)
+		case <-timer.C:
+			logger.Warn("prompt timed out", "prompt", p.ID)
+			http.Error(w, "no answer within "+askpassTimeout.String(), http.StatusGatewayTimeout)
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// askpassPrompts lists the prompts git is waiting on, for the extension
+// to poll.
+func askpassPrompts() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !setupPromptResponse(&w, r) {
+			return
+		}
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		writeJSON(w, http.StatusOK, prompts.list())
+	})
+}
+
+func askpassReply() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !setupPromptResponse(&w, r) {
+			return
+		}
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg askpassAnswer
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		if !prompts.deliver(msg) {
+			http.Error(w, "no such prompt", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
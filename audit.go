@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditFileName is the audit trail's name inside the log directory.
+const auditFileName = "audit.jsonl"
+
+// auditedPaths are the endpoints that change a repository or its remote.
+var auditedPaths = map[string]bool{
+	"/gitClone": true, "/checkoutPR": true,
+	"/stage": true, "/commit": true, "/push": true, "/fetch": true, "/gitPush": true, "/gitPull": true,
+	"/resolveConflict": true, "/continueMerge": true, "/abortMerge": true,
+	"/createBranch": true, "/switchBranch": true, "/renameBranch": true, "/deleteBranch": true,
+	"/addWorktree": true, "/removeWorktree": true, "/pruneWorktrees": true,
+	"/stash": true, "/applyStash": true, "/popStash": true, "/dropStash": true,
+	"/createTag": true, "/deleteTag": true, "/pushTag": true,
+	"/addRemote": true, "/renameRemote": true, "/removeRemote": true, "/setRemoteURL": true, "/syncFork": true,
+}
+
+// auditOmitted request fields either identify the repository, which has a
+// field of its own, or hold file contents that do not belong in the trail.
+var auditOmitted = map[string]bool{
+	"RootPath": true, "Domain": true, "GitUserName": true, "ProjectName": true, "Content": true,
+}
+
+type auditRecord struct {
+	Time         time.Time              `json:"Time"`
+	RequestID    string                 `json:"RequestID"`
+	Origin       string                 `json:"Origin"`
+	RemoteAddr   string                 `json:"RemoteAddr"`
+	Operation    string                 `json:"Operation"`
+	Repo         string                 `json:"Repo"`
+	Request      map[string]interface{} `json:"Request"`
+	BranchBefore string                 `json:"BranchBefore"`
+	BranchAfter  string                 `json:"BranchAfter"`
+	HeadBefore   string                 `json:"HeadBefore"`
+	HeadAfter    string                 `json:"HeadAfter"`
+	Status       int                    `json:"Status"`
+	Success      bool                   `json:"Success"`
+	FailedStep   string                 `json:"FailedStep,omitempty"`
+	Reason       string                 `json:"Reason,omitempty"`
+	DurationMs   float64                `json:"DurationMs"`
+}
+
+// auditTrail appends records to a JSON lines file that is never rewritten.
+type auditTrail struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// audit is nil, and auditing off, until server() opens the trail.
+var audit *auditTrail
+
+func openAuditTrail(path string) (*auditTrail, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditTrail{path: path, f: f}, nil
+}
+
+func (a *auditTrail) append(rec auditRecord) error {
+	js, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(append([]byte(redact(string(js))), '\n'))
+	return err
+}
+
+func (a *auditTrail) Close() error {
+	return a.f.Close()
+}
+
+// query returns the records for repo (all repositories when empty) made
+// within [since, until), oldest first and at most limit of the newest.
+func (a *auditTrail) query(repo string, since, until time.Time, limit int) ([]auditRecord, error) {
+	records := []auditRecord{}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return records, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec auditRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			continue
+		}
+		if repo != "" && filepath.Clean(rec.Repo) != filepath.Clean(repo) {
+			continue
+		}
+		if (!since.IsZero() && rec.Time.Before(since)) || (!until.IsZero() && !rec.Time.Before(until)) {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records, scanner.Err()
+}
+
+// bodyRecorder keeps a copy of the response for the audit record.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *bodyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *bodyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// repoHead returns the branch and commit checked out in dir. It reads them
+// from the repository instead of running git, so auditing neither waits
+// for a job slot nor shows up in the git metrics.
+func repoHead(dir string) (string, string) {
+	if dir == "" {
+		return "", ""
+	}
+	r, err := openRepo(dir)
+	if err != nil {
+		return "", ""
+	}
+	return headOf(r)
+}
+
+// auditedRepo returns the checkout a request changes, found the way its
+// handler finds it.
+func auditedRepo(path string, body []byte) string {
+	if path == "/checkoutPR" {
+		var msg checkoutPRRequest
+		if json.Unmarshal(body, &msg) != nil || resolvePullRequest(&msg) != nil {
+			return ""
+		}
+		return msg.repoPath()
+	}
+	var msg gitData
+	if json.Unmarshal(body, &msg) != nil || msg.ProjectName == "" {
+		return ""
+	}
+	return msg.checkoutPath()
+}
+
+// auditing records every call to an audited endpoint with the repository's
+// HEAD before and after it and the outcome reported to the caller.
+func auditing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if audit == nil || r.Method != "POST" || !auditedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		fields := map[string]interface{}{}
+		json.Unmarshal(body, &fields)
+		for k := range auditOmitted {
+			delete(fields, k)
+		}
+
+		requestID, _ := r.Context().Value(requestIDKey).(string)
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			origin = r.Header.Get("Referer")
+		}
+		rec := auditRecord{
+			Time:       time.Now(),
+			RequestID:  requestID,
+			Origin:     origin,
+			RemoteAddr: r.RemoteAddr,
+			Operation:  strings.TrimPrefix(r.URL.Path, "/"),
+			Repo:       auditedRepo(r.URL.Path, body),
+			Request:    fields,
+		}
+		rec.BranchBefore, rec.HeadBefore = repoHead(rec.Repo)
+
+		recorder := &bodyRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		rec.DurationMs = float64(time.Since(rec.Time)) / float64(time.Millisecond)
+		rec.BranchAfter, rec.HeadAfter = repoHead(rec.Repo)
+		rec.Status = recorder.status
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		rec.Success = rec.Status < http.StatusBadRequest
+
+		var outcome struct {
+			Success    *bool  `json:"Success"`
+			FailedStep string `json:"FailedStep"`
+			Reason     string `json:"Reason"`
+		}
+		if json.Unmarshal(recorder.body.Bytes(), &outcome) == nil {
+			if outcome.Success != nil {
+				rec.Success = *outcome.Success
+			}
+			rec.FailedStep, rec.Reason = outcome.FailedStep, outcome.Reason
+		} else if !rec.Success {
+			rec.Reason = strings.TrimSpace(recorder.body.String())
+		}
+
+		if err := audit.append(rec); err != nil {
+			logger.Error("could not write the audit trail", "path", audit.path, "err", err)
+		}
+	})
+}
+
+// auditQuery returns audit records. Query parameters: repo (a checkout
+// path), since and until (RFC 3339 times) and limit (default 100).
+func auditQuery() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		if audit == nil {
+			http.Error(w, "the audit trail is disabled", http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query()
+		var since, until time.Time
+		for name, t := range map[string]*time.Time{"since": &since, "until": &until} {
+			if v := q.Get(name); v != "" {
+				parsed, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					http.Error(w, name+" must be an RFC 3339 time", http.StatusBadRequest)
+					return
+				}
+				*t = parsed
+			}
+		}
+		limit := 100
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		records, err := audit.query(q.Get("repo"), since, until, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditTrail(t *testing.T) {
+	it := newIntegration(t)
+	defer os.RemoveAll(it.tmp)
+
+	trail, err := openAuditTrail(filepath.Join(it.tmp, "logs", auditFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trail.Close()
+	defer func(old *auditTrail) { audit = old }(audit)
+	audit = trail
+	defer it.start()()
+
+	bare, remote := it.bareRepo("demo")
+	clone := filepath.Join(it.root, "example.com", "alice", "demo")
+	start := time.Now().Add(-time.Second)
+
+	it.post("/gitClone", map[string]interface{}{"RepoURL": remote}, nil)
+	initial := it.git(clone, "rev-parse", "HEAD")
+	it.write(filepath.Join(clone, "README.md"), "edited\n")
+	it.post("/gitPush", map[string]interface{}{"GitMsg": "Edit"}, nil)
+	edited := it.git(clone, "rev-parse", "HEAD")
+	it.post("/deleteBranch", map[string]interface{}{"Name": "missing"}, nil)
+	it.git(bare, "update-ref", "refs/pull/1/head", "main")
+	it.post("/checkoutPR", map[string]interface{}{
+		"ProjectName": "", "PullURL": "https://example.com/alice/demo/pull/1", "RepoURL": remote,
+	}, nil)
+	it.post("/status", nil, nil)
+
+	query := func(params url.Values) []auditRecord {
+		t.Helper()
+		resp, err := http.Get(it.url + "/audit?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var records []auditRecord
+		if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	records := query(url.Values{"repo": {clone}, "since": {start.Format(time.RFC3339)}})
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want clone, gitPush, deleteBranch and checkoutPR: %+v", len(records), records)
+	}
+	clonedRec, pushed, deleted, review := records[0], records[1], records[2], records[3]
+	if clonedRec.Operation != "gitClone" || !clonedRec.Success || clonedRec.HeadBefore != "" || clonedRec.HeadAfter != initial {
+		t.Errorf("clone record = %+v", clonedRec)
+	}
+	if pushed.Operation != "gitPush" || !pushed.Success || pushed.HeadBefore != initial || pushed.HeadAfter != edited || pushed.BranchAfter != "main" {
+		t.Errorf("push record = %+v", pushed)
+	}
+	if pushed.Request["GitMsg"] != "Edit" || pushed.Request["RootPath"] != nil {
+		t.Errorf("push record request = %v", pushed.Request)
+	}
+	if pushed.RequestID == "" {
+		t.Error("push record has no request ID")
+	}
+	if deleted.Operation != "deleteBranch" || deleted.Success || deleted.Reason == "" {
+		t.Errorf("delete record = %+v, want a failure with a reason", deleted)
+	}
+
+	if review.Operation != "checkoutPR" || !review.Success || review.BranchBefore != "main" || review.BranchAfter != "pr/1" || review.HeadBefore != edited {
+		t.Errorf("checkoutPR record = %+v, want a switch from main to pr/1", review)
+	}
+
+	gitCommands.mu.Lock()
+	for key := range gitCommands.values {
+		if strings.HasPrefix(key, "symbolic-ref\xff") {
+			t.Errorf("reading HEAD for the audit trail ran git: %q", key)
+		}
+	}
+	gitCommands.mu.Unlock()
+
+	if got := query(url.Values{"repo": {filepath.Join(it.root, "other")}}); len(got) != 0 {
+		t.Errorf("another repository has %d records", len(got))
+	}
+	if got := query(url.Values{"until": {start.Format(time.RFC3339)}}); len(got) != 0 {
+		t.Errorf("%d records before the test started", len(got))
+	}
+	if got := query(url.Values{"limit": {"1"}}); len(got) != 1 || got[0].Operation != "checkoutPR" {
+		t.Errorf("limit=1 returned %+v, want the newest record", got)
+	}
+}
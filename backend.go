@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Values accepted by the -git-backend flag.
+const (
+	backendAuto = "auto"
+	backendExec = "exec"
+	backendGo   = "go"
+)
+
+// minGitVersion is the oldest git the exec backend supports; `git switch`
+// first appeared in 2.23.
+var minGitVersion = [2]int{2, 23}
+
+// GitBackend carries out the everyday operations: clone, status, add,
+// commit, fetch, pull and push. The other endpoints always use the git
+// binary.
+type GitBackend interface {
+	Name() string
+	// Clone clones url into a new directory under dir named after the
+	// repository.
+	Clone(ctx context.Context, dir, url string) gitStep
+	Status(ctx context.Context, dir string) (statusResult, gitStep)
+	// Add stages paths, or every change when paths is empty.
+	Add(ctx context.Context, dir string, paths []string) gitStep
+	Commit(ctx context.Context, dir, message string) gitStep
+	// Fetch fetches remote, or every remote when it is empty.
+	Fetch(ctx context.Context, dir, remote string) gitStep
+	Pull(ctx context.Context, dir string, msg pullRequest) pullResult
+	// Push pushes branch, or the current one when it is empty, to remote
+	// and sets it as upstream.
+	Push(ctx context.Context, dir, remote, branch string) gitStep
+}
+
+// backend is the GitBackend chosen at startup by chooseBackend.
+var backend GitBackend = execBackend{}
+
+// chooseBackend returns the backend named by the -git-backend flag. In
+// auto mode the git binary is used unless it is missing or too old. The
+// version it finds is cached for the health and diagnostics endpoints.
+func chooseBackend(ctx context.Context, name string) (GitBackend, error) {
+	switch name {
+	case backendExec:
+		installedGitVersion(ctx)
+		return execBackend{}, nil
+	case backendGo:
+		return goBackend{}, nil
+	case backendAuto:
+		if _, err := installedGitVersion(ctx); err != nil {
+			return goBackend{}, nil
+		}
+		return execBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown git backend %q; use auto, exec or go", name)
+}
+
+// gitVersion caches the output of `git version` once it has run, as the
+// git on PATH does not change while the server is up.
+var gitVersion struct {
+	sync.Mutex
+	version string
+}
+
+// installedGitVersion returns the version of git on PATH, or an error if
+// there is none or it is older than minGitVersion. git runs like any other
+// command, under ctx and in a job slot, until a version has been cached.
+func installedGitVersion(ctx context.Context) (string, error) {
+	gitVersion.Lock()
+	version := gitVersion.version
+	gitVersion.Unlock()
+	if version == "" {
+		s := runGit(ctx, os.TempDir(), "version", "version")
+		if !s.ok() {
+			return "", errors.New(s.reason())
+		}
+		// "git version 2.39.2" or "git version 2.39.2.windows.1"
+		version = strings.TrimPrefix(strings.TrimSpace(s.Stdout), "git version ")
+		gitVersion.Lock()
+		gitVersion.version = version
+		gitVersion.Unlock()
+	}
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version, fmt.Errorf("cannot parse git version %q", version)
+	}
+	major, _ := strconv.Atoi(parts[0])
+	minor, _ := strconv.Atoi(parts[1])
+	if major < minGitVersion[0] || major == minGitVersion[0] && minor < minGitVersion[1] {
+		return version, fmt.Errorf("git %s is older than %d.%d", version, minGitVersion[0], minGitVersion[1])
+	}
+	return version, nil
+}
+
+// execBackend runs the git binary found on PATH.
+type execBackend struct{}
+
+func (execBackend) Name() string { return backendExec }
+
+func (execBackend) Clone(ctx context.Context, dir, url string) gitStep {
+	return runGit(ctx, dir, "clone", "clone", url)
+}
+
+func (execBackend) Status(ctx context.Context, dir string) (statusResult, gitStep) {
+	return readStatus(ctx, dir)
+}
+
+func (execBackend) Add(ctx context.Context, dir string, paths []string) gitStep {
+	return stageStep(ctx, dir, paths)
+}
+
+func (execBackend) Commit(ctx context.Context, dir, message string) gitStep {
+	return commitStep(ctx, dir, message)
+}
+
+func (execBackend) Fetch(ctx context.Context, dir, remote string) gitStep {
+	return fetchStep(ctx, dir, remote)
+}
+
+func (execBackend) Push(ctx context.Context, dir, remote, branch string) gitStep {
+	return pushStep(ctx, dir, remote, branch)
+}
+
+// Pull runs git pull with the strategy in msg and works out what changed.
+func (execBackend) Pull(ctx context.Context, dir string, msg pullRequest) pullResult {
+	res := pullResult{
+		gitResult:       newGitResult(),
+		OldHead:         gitOutput(ctx, dir, "rev-parse", "--verify", "-q", "HEAD"),
+		FilesChanged:    []string{},
+		ConflictedFiles: []string{},
+	}
+	args, _ := pullArgs(msg)
+	res.add(runGit(ctx, dir, "pull", args...))
+
+	res.NewHead = gitOutput(ctx, dir, "rev-parse", "--verify", "-q", "HEAD")
+	if res.NewHead != res.OldHead && res.NewHead != "" {
+		// Count against what was fetched rather than the new HEAD, so
+		// local commits replayed by a rebase are not counted as pulled.
+		pulled := gitOutput(ctx, dir, "rev-parse", "--verify", "-q", "FETCH_HEAD")
+		if pulled == "" {
+			pulled = res.NewHead
+		}
+		if res.OldHead == "" {
+			res.FilesChanged = runGit(ctx, dir, "files", "ls-tree", "-r", "--name-only", res.NewHead).lines()
+			res.CommitsPulled, _ = strconv.Atoi(gitOutput(ctx, dir, "rev-list", "--count", pulled))
+		} else {
+			res.FilesChanged = runGit(ctx, dir, "files", "diff", "--name-only", res.OldHead, res.NewHead).lines()
+			res.CommitsPulled, _ = strconv.Atoi(gitOutput(ctx, dir, "rev-list", "--count", res.OldHead+".."+pulled))
+		}
+	}
+	res.ConflictedFiles = conflictedFiles(ctx, dir)
+	res.Conflicts = len(res.ConflictedFiles) > 0
+	return res
+}
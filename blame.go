@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type blameRequest struct {
+	gitData
+	Path      string `json:"Path"`
+	Ref       string `json:"Ref"`
+	StartLine int    `json:"StartLine"`
+	EndLine   int    `json:"EndLine"`
+}
+
+type blameLine struct {
+	Line         int    `json:"Line"`
+	OriginalLine int    `json:"OriginalLine"`
+	Commit       string `json:"Commit"`
+	Uncommitted  bool   `json:"Uncommitted"`
+	Author       string `json:"Author"`
+	AuthorEmail  string `json:"AuthorEmail"`
+	AuthorTime   string `json:"AuthorTime"`
+	Summary      string `json:"Summary"`
+	Content      string `json:"Content"`
+}
+
+// blameCommit holds the headers porcelain output prints only the first
+// time a commit appears.
+type blameCommit struct {
+	author, email, time, summary string
+}
+
+// isObjectName reports whether s is a full SHA-1 or SHA-256 object name.
+func isObjectName(s string) bool {
+	return (len(s) == 40 || len(s) == 64) && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// parseBlame reads `git blame --porcelain` output.
+func parseBlame(out string) []blameLine {
+	lines := []blameLine{}
+	commits := map[string]*blameCommit{}
+	var cur blameLine
+	var info *blameCommit
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, "\t") && info != nil {
+			// The content line ends each entry.
+			cur.Content = line[1:]
+			cur.Author, cur.AuthorEmail, cur.AuthorTime, cur.Summary = info.author, info.email, info.time, info.summary
+			lines = append(lines, cur)
+			continue
+		}
+		if f := strings.Fields(line); len(f) >= 3 && isObjectName(f[0]) {
+			// "<commit> <original line> <final line> [<group size>]"
+			cur = blameLine{Commit: f[0], Uncommitted: strings.Trim(f[0], "0") == ""}
+			cur.OriginalLine, _ = strconv.Atoi(f[1])
+			cur.Line, _ = strconv.Atoi(f[2])
+			if info = commits[f[0]]; info == nil {
+				info = &blameCommit{}
+				commits[f[0]] = info
+			}
+			continue
+		}
+		if info == nil {
+			continue
+		}
+		key, value := line, ""
+		if sp := strings.IndexByte(line, ' '); sp >= 0 {
+			key, value = line[:sp], line[sp+1:]
+		}
+		switch key {
+		case "author":
+			info.author = value
+		case "author-mail":
+			info.email = strings.Trim(value, "<>")
+		case "author-time":
+			if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+				info.time = time.Unix(secs, 0).UTC().Format(time.RFC3339)
+			}
+		case "summary":
+			info.summary = value
+		}
+	}
+	return lines
+}
+
+// blame runs git blame on a repo-relative path. Without a Ref the file as
+// it is on disk is blamed, so uncommitted lines come back marked as such.
+func blame() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg blameRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		dir := msg.checkoutPath()
+		if _, err := repoFile(dir, msg.Path); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(msg.Ref, "-") {
+			http.Error(w, "invalid Ref", http.StatusBadRequest)
+			return
+		}
+
+		args := []string{"blame", "--porcelain"}
+		if msg.StartLine > 0 || msg.EndLine > 0 {
+			start, end := msg.StartLine, ""
+			if start <= 0 {
+				start = 1
+			}
+			if msg.EndLine > 0 {
+				end = strconv.Itoa(msg.EndLine)
+			}
+			args = append(args, "-L", strconv.Itoa(start)+","+end)
+		}
+		if msg.Ref != "" {
+			args = append(args, msg.Ref)
+		}
+		args = append(args, "--", filepath.ToSlash(msg.Path))
+
+		s := runGit(ctx, dir, "blame", args...)
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, parseBlame(s.Stdout))
+	})
+}
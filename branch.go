@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type branchInfo struct {
+	Name          string `json:"Name"`
+	Remote        bool   `json:"Remote"`
+	Current       bool   `json:"Current"`
+	Upstream      string `json:"Upstream"`
+	UpstreamGone  bool   `json:"UpstreamGone"`
+	Ahead         int    `json:"Ahead"`
+	Behind        int    `json:"Behind"`
+	Commit        string `json:"Commit"`
+	CommitDate    string `json:"CommitDate"`
+	CommitSubject string `json:"CommitSubject"`
+}
+
+type branchRequest struct {
+	gitData
+	Name       string `json:"Name"`
+	NewName    string `json:"NewName"`
+	StartPoint string `json:"StartPoint"`
+	Checkout   bool   `json:"Checkout"`
+	Stash      bool   `json:"Stash"`
+	Force      bool   `json:"Force"`
+}
+
+const branchFormat = "%(refname)%00%(refname:short)%00%(HEAD)%00%(upstream:short)%00%(upstream:track,nobracket)%00%(objectname)%00%(committerdate:iso-strict)%00%(contents:subject)"
+
+// listBranches reads local and remote-tracking branches with their
+// upstream and the commit they point at.
+func listBranches(ctx context.Context, dir string) ([]branchInfo, gitStep) {
+	branches := []branchInfo{}
+	s := runGit(ctx, dir, "branches", "for-each-ref", "--format="+branchFormat, "refs/heads", "refs/remotes")
+	if !s.ok() {
+		return branches, s
+	}
+	for _, line := range s.lines() {
+		f := strings.Split(line, "\x00")
+		if len(f) != 8 || strings.HasSuffix(f[0], "/HEAD") {
+			continue
+		}
+		b := branchInfo{
+			Name:          f[1],
+			Remote:        strings.HasPrefix(f[0], "refs/remotes/"),
+			Current:       f[2] == "*",
+			Upstream:      f[3],
+			Commit:        f[5],
+			CommitDate:    f[6],
+			CommitSubject: f[7],
+		}
+		// The track field reads like "ahead 1, behind 2" or "gone".
+		for _, part := range strings.Split(f[4], ", ") {
+			switch {
+			case part == "gone":
+				b.UpstreamGone = true
+			case strings.HasPrefix(part, "ahead "):
+				b.Ahead, _ = strconv.Atoi(strings.TrimPrefix(part, "ahead "))
+			case strings.HasPrefix(part, "behind "):
+				b.Behind, _ = strconv.Atoi(strings.TrimPrefix(part, "behind "))
+			}
+		}
+		branches = append(branches, b)
+	}
+	return branches, s
+}
+
+// checkBranchName rejects names git would not accept for a branch.
+func checkBranchName(ctx context.Context, dir, name string) gitStep {
+	return runGit(ctx, dir, "check-name", "check-ref-format", "--branch", name)
+}
+
+func branches() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		list, s := listBranches(ctx, msg.checkoutPath())
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+}
+
+// branchHandler wraps the boilerplate shared by the branch mutations: it
+// decodes the request, requires Name, refuses names and start points git
+// would read as options, and runs the steps built by plan.
+func branchHandler(plan func(ctx context.Context, w http.ResponseWriter, dir string, msg branchRequest) []func() gitStep) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg branchRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if msg.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		if rejectOption(w, "Name", msg.Name) || rejectOption(w, "NewName", msg.NewName) || rejectOption(w, "StartPoint", msg.StartPoint) {
+			return
+		}
+		repoPath := msg.checkoutPath()
+		steps := plan(ctx, w, repoPath, msg)
+		if steps == nil {
+			return
+		}
+
+		res := newGitResult()
+		res.run(steps...)
+		if !res.Success {
+			logger.Warn("branch operation failed", "branch", msg.Name, "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeResult(w, res)
+	})
+}
+
+func createBranch() http.Handler {
+	return branchHandler(func(ctx context.Context, w http.ResponseWriter, dir string, msg branchRequest) []func() gitStep {
+		args := []string{"branch", msg.Name}
+		if msg.StartPoint != "" {
+			args = append(args, msg.StartPoint)
+		}
+		steps := []func() gitStep{
+			func() gitStep { return checkBranchName(ctx, dir, msg.Name) },
+			func() gitStep { return runGit(ctx, dir, "create", args...) },
+		}
+		if msg.Checkout {
+			steps = append(steps, func() gitStep { return runGit(ctx, dir, "switch", "switch", msg.Name) })
+		}
+		return steps
+	})
+}
+
+// switchBranch refuses to leave a dirty working tree unless Stash is set,
+// in which case local changes are stashed first.
+func switchBranch() http.Handler {
+	return branchHandler(func(ctx context.Context, w http.ResponseWriter, dir string, msg branchRequest) []func() gitStep {
+		st, s := readStatus(ctx, dir)
+		if !s.ok() {
+			return []func() gitStep{func() gitStep { return s }}
+		}
+		dirty := len(st.Changes) > 0 || len(st.Conflicts) > 0
+		if dirty && !msg.Stash {
+			http.Error(w, "working tree has uncommitted changes; commit them or set Stash", http.StatusConflict)
+			return nil
+		}
+
+		steps := []func() gitStep{}
+		if dirty {
+			steps = append(steps, func() gitStep {
+				return runGit(ctx, dir, "stash", "stash", "push", "--include-untracked", "-m", "gitify: switching to "+msg.Name)
+			})
+		}
+		return append(steps, func() gitStep { return runGit(ctx, dir, "switch", "switch", msg.Name) })
+	})
+}
+
+func renameBranch() http.Handler {
+	return branchHandler(func(ctx context.Context, w http.ResponseWriter, dir string, msg branchRequest) []func() gitStep {
+		if msg.NewName == "" {
+			http.Error(w, "NewName is required", http.StatusBadRequest)
+			return nil
+		}
+		return []func() gitStep{
+			func() gitStep { return checkBranchName(ctx, dir, msg.NewName) },
+			func() gitStep { return runGit(ctx, dir, "rename", "branch", "-m", msg.Name, msg.NewName) },
+		}
+	})
+}
+
+// deleteBranch relies on `git branch -d`, which refuses to drop commits not
+// merged into the branch's upstream or HEAD; Force overrides that check.
+func deleteBranch() http.Handler {
+	return branchHandler(func(ctx context.Context, w http.ResponseWriter, dir string, msg branchRequest) []func() gitStep {
+		flag := "-d"
+		if msg.Force {
+			flag = "-D"
+		}
+		return []func() gitStep{
+			func() gitStep { return runGit(ctx, dir, "delete", "branch", flag, msg.Name) },
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type stageRequest struct {
+	gitData
+	Paths []string `json:"Paths"`
+}
+
+type pushRequest struct {
+	gitData
+	Remote string `json:"Remote"`
+	Branch string `json:"Branch"`
+}
+
+type fetchRequest struct {
+	gitData
+	Remote string `json:"Remote"`
+}
+
+type commitPushRequest struct {
+	gitData
+	Paths  []string `json:"Paths"`
+	Remote string   `json:"Remote"`
+	Branch string   `json:"Branch"`
+}
+
+// stageStep adds paths to the index, or every change when paths is empty.
+func stageStep(ctx context.Context, dir string, paths []string) gitStep {
+	if len(paths) == 0 {
+		return runGit(ctx, dir, "stage", "add", "-A")
+	}
+	return runGit(ctx, dir, "stage", append([]string{"add", "--"}, paths...)...)
+}
+
+func commitStep(ctx context.Context, dir, message string) gitStep {
+	if message == "" {
+		return gitStep{Step: "commit", ExitCode: -1, Error: "commit message is required"}
+	}
+	return runGit(ctx, dir, "commit", "commit", "-m", message)
+}
+
+// pushStep pushes branch to remote and sets it as upstream. Without an
+// explicit branch the current one is pushed under the same name.
+func pushStep(ctx context.Context, dir, remote, branch string) gitStep {
+	if remote == "" {
+		remote = "origin"
+	}
+	if branch == "" {
+		branch = "HEAD"
+	}
+	return runGit(ctx, dir, "push", "push", "-u", "--", remote, branch)
+}
+
+func fetchStep(ctx context.Context, dir, remote string) gitStep {
+	if remote == "" {
+		return runGit(ctx, dir, "fetch", "fetch", "--all", "--prune")
+	}
+	return runGit(ctx, dir, "fetch", "fetch", "--prune", "--", remote)
+}
+
+// runSingle serves an endpoint that runs exactly one git step.
+func runSingle(w http.ResponseWriter, s gitStep) {
+	res := newGitResult()
+	if !res.add(s) {
+		logger.Warn("git step failed", "step", s.Step, "reason", res.Reason)
+	}
+	writeResult(w, res)
+}
+
+func stage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg stageRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		runSingle(w, backend.Add(ctx, msg.checkoutPath(), msg.Paths))
+	})
+}
+
+func commit() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		runSingle(w, backend.Commit(ctx, msg.checkoutPath(), msg.GitMsg))
+	})
+}
+
+func push() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg pushRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		if rejectOption(w, "Remote", msg.Remote) || rejectOption(w, "Branch", msg.Branch) {
+			return
+		}
+		ctx := r.Context()
+		runSingle(w, backend.Push(ctx, msg.checkoutPath(), msg.Remote, msg.Branch))
+	})
+}
+
+func fetch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg fetchRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		if rejectOption(w, "Remote", msg.Remote) {
+			return
+		}
+		ctx := r.Context()
+		runSingle(w, backend.Fetch(ctx, msg.checkoutPath(), msg.Remote))
+	})
+}
+
+// gitPush stages, commits and pushes in one call, stopping at the first
+// step that fails so a failed commit never pushes stale state.
+func gitPush() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg commitPushRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		if rejectOption(w, "Remote", msg.Remote) || rejectOption(w, "Branch", msg.Branch) {
+			return
+		}
+		ctx := r.Context()
+		repoPath := msg.checkoutPath()
+
+		res := newGitResult()
+		res.run(
+			func() gitStep { return backend.Add(ctx, repoPath, msg.Paths) },
+			func() gitStep { return backend.Commit(ctx, repoPath, msg.GitMsg) },
+			func() gitStep { return backend.Push(ctx, repoPath, msg.Remote, msg.Branch) },
+		)
+
+		if !res.Success {
+			logger.Warn("gitPush stopped", "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeResult(w, res)
+	})
+}
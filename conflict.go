@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Operations that can leave a repository with unresolved conflicts.
+const (
+	opMerge      = "merge"
+	opRebase     = "rebase"
+	opCherryPick = "cherry-pick"
+	opRevert     = "revert"
+)
+
+// Conflict sides accepted by resolveConflictRequest.Side. For a rebase git
+// swaps their meaning: "ours" is the branch being rebased onto.
+const (
+	sideOurs   = "ours"
+	sideTheirs = "theirs"
+)
+
+// conflictVersion is one stage of a conflicted file in the index.
+type conflictVersion struct {
+	Present bool   `json:"Present"`
+	Binary  bool   `json:"Binary"`
+	Content string `json:"Content"`
+}
+
+type conflictFile struct {
+	Path   string          `json:"Path"`
+	Base   conflictVersion `json:"Base"`
+	Ours   conflictVersion `json:"Ours"`
+	Theirs conflictVersion `json:"Theirs"`
+}
+
+type conflictList struct {
+	Operation string         `json:"Operation"`
+	Files     []conflictFile `json:"Files"`
+}
+
+type resolveConflictRequest struct {
+	gitData
+	Path    string  `json:"Path"`
+	Side    string  `json:"Side"`
+	Content *string `json:"Content"`
+}
+
+// operationInProgress reports which merge-like operation is paused in dir,
+// or the empty string if none is.
+func operationInProgress(ctx context.Context, dir string) string {
+	return operationIn(gitOutput(ctx, dir, "rev-parse", "--absolute-git-dir"))
+}
+
+// operationIn looks for the files git leaves in gitDir while a merge-like
+// operation is paused.
+func operationIn(gitDir string) string {
+	if gitDir == "" {
+		return ""
+	}
+	markers := []struct {
+		name string
+		op   string
+	}{
+		{"rebase-merge", opRebase},
+		{"rebase-apply", opRebase},
+		{"MERGE_HEAD", opMerge},
+		{"CHERRY_PICK_HEAD", opCherryPick},
+		{"REVERT_HEAD", opRevert},
+	}
+	for _, m := range markers {
+		if ok, _ := exists(filepath.Join(gitDir, m.name)); ok {
+			return m.op
+		}
+	}
+	return ""
+}
+
+// conflictVersions reads the base, ours and theirs stages of every
+// conflicted path from the index.
+func conflictVersions(ctx context.Context, dir string) []conflictFile {
+	files := []conflictFile{}
+	index := map[string]int{}
+	out := runGit(ctx, dir, "conflicts", "ls-files", "-u", "-z")
+	for _, entry := range strings.Split(out.Stdout, "\x00") {
+		// <mode> SP <object> SP <stage> TAB <path>
+		tab := strings.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+		fields := strings.Fields(entry[:tab])
+		if len(fields) != 3 {
+			continue
+		}
+		path := entry[tab+1:]
+		i, ok := index[path]
+		if !ok {
+			i = len(files)
+			index[path] = i
+			files = append(files, conflictFile{Path: path})
+		}
+		v := readBlob(ctx, dir, fields[1])
+		switch fields[2] {
+		case "1":
+			files[i].Base = v
+		case "2":
+			files[i].Ours = v
+		case "3":
+			files[i].Theirs = v
+		}
+	}
+	return files
+}
+
+func readBlob(ctx context.Context, dir, object string) conflictVersion {
+	s := runGit(ctx, dir, "show", "cat-file", "blob", object)
+	if !s.ok() {
+		return conflictVersion{}
+	}
+	if bytes.IndexByte([]byte(s.Stdout), 0) >= 0 {
+		return conflictVersion{Present: true, Binary: true}
+	}
+	return conflictVersion{Present: true, Content: s.Stdout}
+}
+
+// abortArgs and continueArgs map an operation to the git command that
+// finishes it. The editor is disabled so git never waits for input.
+var (
+	abortArgs = map[string][]string{
+		opMerge:      {"merge", "--abort"},
+		opRebase:     {"rebase", "--abort"},
+		opCherryPick: {"cherry-pick", "--abort"},
+		opRevert:     {"revert", "--abort"},
+	}
+	continueArgs = map[string][]string{
+		opMerge:      {"-c", "core.editor=true", "commit", "--no-edit"},
+		opRebase:     {"-c", "core.editor=true", "rebase", "--continue"},
+		opCherryPick: {"-c", "core.editor=true", "cherry-pick", "--continue"},
+		opRevert:     {"-c", "core.editor=true", "revert", "--continue"},
+	}
+)
+
+func conflicts() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		repoPath := msg.checkoutPath()
+		writeJSON(w, http.StatusOK, conflictList{
+			Operation: operationInProgress(ctx, repoPath),
+			Files:     conflictVersions(ctx, repoPath),
+		})
+	})
+}
+
+// resolveConflict settles one file, either by taking a side or by writing
+// the merged Content supplied by the browser, and then stages it.
+func resolveConflict() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg resolveConflictRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		repoPath := msg.checkoutPath()
+		file, err := repoFile(repoPath, msg.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		path := filepath.ToSlash(msg.Path)
+
+		res := newGitResult()
+		switch {
+		case msg.Content != nil:
+			if err := ioutil.WriteFile(file, []byte(*msg.Content), 0644); err != nil {
+				res.add(gitStep{Step: "write", ExitCode: -1, Error: err.Error()})
+			}
+		case msg.Side == sideOurs || msg.Side == sideTheirs:
+			res.add(runGit(ctx, repoPath, "checkout", "checkout", "--"+msg.Side, "--", path))
+		default:
+			http.Error(w, "either Side (ours or theirs) or Content is required", http.StatusBadRequest)
+			return
+		}
+		res.run(func() gitStep { return runGit(ctx, repoPath, "stage", "add", "--", path) })
+
+		if !res.Success {
+			logger.Warn("resolveConflict failed", "path", msg.Path, "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeResult(w, res)
+	})
+}
+
+// finishMerge returns a handler that continues or aborts whichever merge,
+// rebase, cherry-pick or revert is in progress.
+func finishMerge(step string, commands map[string][]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		repoPath := msg.checkoutPath()
+		op := operationInProgress(ctx, repoPath)
+		if op == "" {
+			http.Error(w, "no merge or rebase in progress", http.StatusConflict)
+			return
+		}
+
+		res := newGitResult()
+		if !res.add(runGit(ctx, repoPath, step, commands[op]...)) {
+			logger.Warn("finishing operation failed", "operation", op, "step", step, "reason", res.Reason)
+		}
+		writeResult(w, res)
+	})
+}
+
+func continueMerge() http.Handler {
+	return finishMerge("continue", continueArgs)
+}
+
+func abortMerge() http.Handler {
+	return finishMerge("abort", abortArgs)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version is stamped at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// windowsAgentPipe is where the OpenSSH agent bundled with Windows listens.
+const windowsAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+type buildInfo struct {
+	Version   string `json:"Version"`
+	Module    string `json:"Module"`
+	GoVersion string `json:"GoVersion"`
+	OS        string `json:"OS"`
+	Arch      string `json:"Arch"`
+}
+
+type gitDiagnostics struct {
+	Backend           string   `json:"Backend"`
+	Path              string   `json:"Path"`
+	Version           string   `json:"Version"`
+	Supported         bool     `json:"Supported"`
+	Error             string   `json:"Error,omitempty"`
+	UserName          string   `json:"UserName"`
+	UserEmail         string   `json:"UserEmail"`
+	UserConfigured    bool     `json:"UserConfigured"`
+	CredentialHelpers []string `json:"CredentialHelpers"`
+}
+
+type toolInfo struct {
+	Available bool   `json:"Available"`
+	Path      string `json:"Path"`
+	Error     string `json:"Error,omitempty"`
+}
+
+type rootInfo struct {
+	Path      string `json:"Path"`
+	Exists    bool   `json:"Exists"`
+	FreeBytes uint64 `json:"FreeBytes"`
+	Error     string `json:"Error,omitempty"`
+}
+
+type diagnosticsReport struct {
+	Server   buildInfo      `json:"Server"`
+	Git      gitDiagnostics `json:"Git"`
+	SSHAgent toolInfo       `json:"SSHAgent"`
+	Editor   toolInfo       `json:"Editor"`
+	Roots    []rootInfo     `json:"Roots"`
+}
+
+func serverBuild() buildInfo {
+	b := buildInfo{Version: version, GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		b.Module = info.Main.Path + "@" + info.Main.Version
+	}
+	return b
+}
+
+// gitSetup reports which git would run and the configuration that most
+// often explains failures. It reads config from outside any repository so
+// only the user's global and system settings apply.
+func gitSetup(ctx context.Context) gitDiagnostics {
+	d := gitDiagnostics{Backend: backend.Name(), CredentialHelpers: []string{}}
+	path, err := exec.LookPath("git")
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	d.Path = path
+	d.Version, err = installedGitVersion(ctx)
+	d.Supported = err == nil
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	dir := os.TempDir()
+	d.UserName = gitOutput(ctx, dir, "config", "--get", "user.name")
+	d.UserEmail = gitOutput(ctx, dir, "config", "--get", "user.email")
+	d.UserConfigured = d.UserName != "" && d.UserEmail != ""
+	d.CredentialHelpers = append(d.CredentialHelpers, runGit(ctx, dir, "config", "config", "--get-all", "credential.helper").lines()...)
+	return d
+}
+
+// sshAgent reports whether an SSH agent is reachable, through
+// SSH_AUTH_SOCK or the Windows OpenSSH agent pipe.
+func sshAgent() toolInfo {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return toolInfo{Path: sock, Error: err.Error()}
+		}
+		conn.Close()
+		return toolInfo{Available: true, Path: sock}
+	}
+	if runtime.GOOS == "windows" {
+		if _, err := os.Stat(windowsAgentPipe); err != nil {
+			return toolInfo{Path: windowsAgentPipe, Error: "the OpenSSH Authentication Agent service is not running"}
+		}
+		return toolInfo{Available: true, Path: windowsAgentPipe}
+	}
+	return toolInfo{Error: "SSH_AUTH_SOCK is not set"}
+}
+
+func lookTool(name string) toolInfo {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return toolInfo{Error: err.Error()}
+	}
+	return toolInfo{Available: true, Path: path}
+}
+
+func rootStatus(path string) rootInfo {
+	info := rootInfo{Path: path}
+	if ok, err := exists(path); !ok {
+		if err != nil {
+			info.Error = err.Error()
+		}
+		return info
+	}
+	info.Exists = true
+	free, err := freeSpace(path)
+	if err != nil {
+		info.Error = err.Error()
+	}
+	info.FreeBytes = free
+	return info
+}
+
+// diagnostics answers "which git, which config?" in one place. Roots are
+// those given with -root plus any passed as repeated root query
+// parameters.
+func diagnostics() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		report := diagnosticsReport{
+			Server:   serverBuild(),
+			Git:      gitSetup(r.Context()),
+			SSHAgent: sshAgent(),
+			Editor:   lookTool("code"),
+			Roots:    []rootInfo{},
+		}
+		for _, root := range append(workspaceRoots, r.URL.Query()["root"]...) {
+			if root = strings.TrimSpace(root); root != "" {
+				report.Roots = append(report.Roots, rootStatus(root))
+			}
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+}
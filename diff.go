@@ -0,0 +1,276 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Diff modes accepted in diffRequest.Mode.
+const (
+	diffWorktree = "worktree"
+	diffStaged   = "staged"
+	diffRefs     = "refs"
+)
+
+const (
+	defaultDiffMaxBytes     = 1 << 20
+	defaultDiffMaxFileLines = 2000
+	defaultDiffContext      = 3
+)
+
+type diffRequest struct {
+	gitData
+	Mode         string `json:"Mode"`
+	From         string `json:"From"`
+	To           string `json:"To"`
+	Path         string `json:"Path"`
+	Context      *int   `json:"Context"`
+	MaxBytes     int    `json:"MaxBytes"`
+	MaxFileLines int    `json:"MaxFileLines"`
+}
+
+type diffLine struct {
+	Type    string `json:"Type"`
+	Content string `json:"Content"`
+	OldLine int    `json:"OldLine,omitempty"`
+	NewLine int    `json:"NewLine,omitempty"`
+}
+
+type diffHunk struct {
+	Header   string     `json:"Header"`
+	OldStart int        `json:"OldStart"`
+	OldLines int        `json:"OldLines"`
+	NewStart int        `json:"NewStart"`
+	NewLines int        `json:"NewLines"`
+	Lines    []diffLine `json:"Lines"`
+}
+
+type diffFile struct {
+	Path       string     `json:"Path"`
+	OldPath    string     `json:"OldPath"`
+	Status     string     `json:"Status"`
+	Binary     bool       `json:"Binary"`
+	Insertions int        `json:"Insertions"`
+	Deletions  int        `json:"Deletions"`
+	Truncated  bool       `json:"Truncated"`
+	Hunks      []diffHunk `json:"Hunks"`
+}
+
+type diffResult struct {
+	Unified   string     `json:"Unified"`
+	Files     []diffFile `json:"Files"`
+	Truncated bool       `json:"Truncated"`
+}
+
+// diffArgs builds the git diff command line for msg, or returns a message
+// explaining why the request is invalid.
+func diffArgs(msg diffRequest) ([]string, string) {
+	context := defaultDiffContext
+	if msg.Context != nil && *msg.Context >= 0 {
+		context = *msg.Context
+	}
+	args := []string{"-c", "core.quotePath=false", "diff", "--no-color", "--no-ext-diff", "-M", "-U" + strconv.Itoa(context)}
+
+	for _, ref := range []string{msg.From, msg.To} {
+		if strings.HasPrefix(ref, "-") {
+			return nil, "invalid ref " + strconv.Quote(ref)
+		}
+	}
+	switch msg.Mode {
+	case "", diffWorktree:
+	case diffStaged:
+		args = append(args, "--cached")
+	case diffRefs:
+		if msg.From == "" {
+			return nil, "From is required for a refs diff"
+		}
+		args = append(args, msg.From)
+		if msg.To != "" {
+			args = append(args, msg.To)
+		}
+	default:
+		return nil, "unknown diff mode " + strconv.Quote(msg.Mode)
+	}
+
+	args = append(args, "--")
+	if msg.Path != "" {
+		args = append(args, filepath.ToSlash(msg.Path))
+	}
+	return args, ""
+}
+
+// diffPath strips the a/ or b/ prefix git puts on paths, unquoting names
+// that contain characters git will not print verbatim.
+func diffPath(p string) string {
+	if strings.HasPrefix(p, "\"") {
+		if unquoted, err := strconv.Unquote(p); err == nil {
+			p = unquoted
+		}
+	}
+	if p == "/dev/null" {
+		return ""
+	}
+	if len(p) > 2 && (p[:2] == "a/" || p[:2] == "b/") {
+		return p[2:]
+	}
+	return p
+}
+
+// parseHunkHeader reads "@@ -oldStart,oldLines +newStart,newLines @@ ...".
+func parseHunkHeader(line string) diffHunk {
+	h := diffHunk{Header: line, Lines: []diffLine{}}
+	f := strings.Fields(line)
+	if len(f) < 3 {
+		return h
+	}
+	parse := func(r string) (int, int) {
+		start, count := r, "1"
+		if comma := strings.IndexByte(r, ','); comma >= 0 {
+			start, count = r[:comma], r[comma+1:]
+		}
+		s, _ := strconv.Atoi(start)
+		c, _ := strconv.Atoi(count)
+		return s, c
+	}
+	h.OldStart, h.OldLines = parse(strings.TrimPrefix(f[1], "-"))
+	h.NewStart, h.NewLines = parse(strings.TrimPrefix(f[2], "+"))
+	return h
+}
+
+// parseDiff turns unified diff output into files and hunks. Files keep
+// their insertion and deletion counts but stop collecting lines once they
+// exceed maxFileLines.
+func parseDiff(out string, maxFileLines int) []diffFile {
+	files := []diffFile{}
+	var file *diffFile
+	var hunk *diffHunk
+	var oldLine, newLine, kept int
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files = append(files, diffFile{Status: "modified", Hunks: []diffHunk{}})
+			file, hunk, kept = &files[len(files)-1], nil, 0
+			// "a/<path> b/<path>"; both halves match unless renamed, in
+			// which case the rename lines below fill in the paths.
+			names := strings.TrimPrefix(line, "diff --git ")
+			if n := (len(names) - 1) / 2; len(names)%2 == 1 && names[:n][2:] == names[n+1:][2:] {
+				file.Path = diffPath(names[n+1:])
+				file.OldPath = file.Path
+			}
+		case file == nil:
+		case hunk == nil && strings.HasPrefix(line, "new file mode"):
+			file.Status, file.OldPath = "added", ""
+		case hunk == nil && strings.HasPrefix(line, "deleted file mode"):
+			file.Status = "deleted"
+		case hunk == nil && strings.HasPrefix(line, "rename from "):
+			file.Status, file.OldPath = "renamed", diffPath(strings.TrimPrefix(line, "rename from "))
+		case hunk == nil && strings.HasPrefix(line, "rename to "):
+			file.Path = diffPath(strings.TrimPrefix(line, "rename to "))
+		case hunk == nil && strings.HasPrefix(line, "copy from "):
+			file.Status, file.OldPath = "copied", diffPath(strings.TrimPrefix(line, "copy from "))
+		case hunk == nil && strings.HasPrefix(line, "copy to "):
+			file.Path = diffPath(strings.TrimPrefix(line, "copy to "))
+		case hunk == nil && strings.HasPrefix(line, "Binary files "):
+			file.Binary = true
+		case hunk == nil && strings.HasPrefix(line, "--- "):
+			file.OldPath = diffPath(strings.TrimPrefix(line, "--- "))
+		case hunk == nil && strings.HasPrefix(line, "+++ "):
+			if p := diffPath(strings.TrimPrefix(line, "+++ ")); p != "" {
+				file.Path = p
+			} else {
+				file.Path = file.OldPath
+			}
+		case strings.HasPrefix(line, "@@ "):
+			file.Hunks = append(file.Hunks, parseHunkHeader(line))
+			hunk = &file.Hunks[len(file.Hunks)-1]
+			oldLine, newLine = hunk.OldStart, hunk.NewStart
+		case hunk == nil || line == "":
+		default:
+			var dl diffLine
+			switch line[0] {
+			case '+':
+				dl = diffLine{Type: "add", Content: line[1:], NewLine: newLine}
+				file.Insertions++
+				newLine++
+			case '-':
+				dl = diffLine{Type: "delete", Content: line[1:], OldLine: oldLine}
+				file.Deletions++
+				oldLine++
+			case ' ':
+				dl = diffLine{Type: "context", Content: line[1:], OldLine: oldLine, NewLine: newLine}
+				oldLine++
+				newLine++
+			default:
+				// "\ No newline at end of file"
+				continue
+			}
+			if kept >= maxFileLines {
+				file.Truncated = true
+				continue
+			}
+			hunk.Lines = append(hunk.Lines, dl)
+			kept++
+		}
+	}
+	return files
+}
+
+func gitDiff() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg diffRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		dir := msg.checkoutPath()
+		if msg.Path != "" {
+			if _, err := repoFile(dir, msg.Path); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		args, invalid := diffArgs(msg)
+		if invalid != "" {
+			http.Error(w, invalid, http.StatusBadRequest)
+			return
+		}
+		if msg.MaxBytes <= 0 {
+			msg.MaxBytes = defaultDiffMaxBytes
+		}
+		if msg.MaxFileLines <= 0 {
+			msg.MaxFileLines = defaultDiffMaxFileLines
+		}
+
+		s := runGit(ctx, dir, "diff", args...)
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+
+		// Cut the output at the last complete line within the size limit;
+		// files past the cut are left out entirely.
+		res := diffResult{Unified: s.Stdout}
+		if len(res.Unified) > msg.MaxBytes {
+			res.Truncated = true
+			res.Unified = res.Unified[:msg.MaxBytes]
+			if nl := strings.LastIndexByte(res.Unified, '\n'); nl >= 0 {
+				res.Unified = res.Unified[:nl+1]
+			}
+		}
+		res.Files = parseDiff(res.Unified, msg.MaxFileLines)
+		if res.Truncated && len(res.Files) > 0 {
+			res.Files[len(res.Files)-1].Truncated = true
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
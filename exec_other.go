@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// hideWindow is a no-op outside Windows, where child processes have no
+// console window of their own.
+func hideWindow(cmd *exec.Cmd) {}
+
+// newProcessGroup starts cmd in a process group of its own so killTree
+// can reach the helpers git spawns, such as ssh and remote-https.
+func newProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killTree kills cmd's whole process group, or just cmd when it was not
+// started with newProcessGroup and so does not lead a group.
+func killTree(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// openCommand opens path with the desktop's default application.
+func openCommand(path string) *exec.Cmd {
+	if runtime.GOOS == "darwin" {
+		return exec.Command("open", path)
+	}
+	return exec.Command("xdg-open", path)
+}
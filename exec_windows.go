@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// hideWindow stops the child process from flashing a console window, which
+// would otherwise happen because the server is built with -H=windowsgui.
+func hideWindow(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000} // CREATE_NO_WINDOW
+}
+
+// newProcessGroup is a no-op on Windows; killTree finds the children by
+// their parent process ID instead.
+func newProcessGroup(cmd *exec.Cmd) {}
+
+// killTree kills cmd along with every process it started, such as the ssh
+// or remote-https helpers git spawns.
+func killTree(cmd *exec.Cmd) error {
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+	hideWindow(kill)
+	if err := kill.Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// openCommand opens path with the application associated with its file
+// type.
+func openCommand(path string) *exec.Cmd {
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+}
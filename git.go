@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// gitStep is the outcome of a single git invocation.
+type gitStep struct {
+	Step      string   `json:"Step"`
+	Args      []string `json:"Args"`
+	Stdout    string   `json:"Stdout"`
+	Stderr    string   `json:"Stderr"`
+	ExitCode  int      `json:"ExitCode"`
+	Error     string   `json:"Error,omitempty"`
+	TimedOut  bool     `json:"TimedOut,omitempty"`
+	Cancelled bool     `json:"Cancelled,omitempty"`
+}
+
+func (s gitStep) ok() bool {
+	return s.Error == ""
+}
+
+// reason returns the most useful explanation of a failed step. git writes
+// most diagnostics to stderr, but a few (such as "nothing to commit") only
+// ever show up on stdout.
+func (s gitStep) reason() string {
+	if s.TimedOut || s.Cancelled {
+		return s.Error
+	}
+	if msg := strings.TrimSpace(s.Stderr); msg != "" {
+		return msg
+	}
+	if msg := strings.TrimSpace(s.Stdout); msg != "" {
+		return msg
+	}
+	return s.Error
+}
+
+// gitResult is the response body shared by every endpoint that runs git.
+// Steps run in order and the first failing one ends the operation.
+type gitResult struct {
+	Success    bool      `json:"Success"`
+	FailedStep string    `json:"FailedStep,omitempty"`
+	Reason     string    `json:"Reason,omitempty"`
+	TimedOut   bool      `json:"TimedOut,omitempty"`
+	Cancelled  bool      `json:"Cancelled,omitempty"`
+	Steps      []gitStep `json:"Steps"`
+}
+
+func newGitResult() *gitResult {
+	return &gitResult{Success: true, Steps: []gitStep{}}
+}
+
+// add records a step and reports whether the operation may continue.
+func (res *gitResult) add(s gitStep) bool {
+	res.Steps = append(res.Steps, s)
+	if !s.ok() && res.Success {
+		res.Success = false
+		res.FailedStep = s.Step
+		res.Reason = s.reason()
+		res.TimedOut = s.TimedOut
+		res.Cancelled = s.Cancelled
+	}
+	return res.Success
+}
+
+// run executes steps in order until one of them fails. Nothing runs if an
+// earlier step has already failed.
+func (res *gitResult) run(steps ...func() gitStep) {
+	for _, step := range steps {
+		if !res.Success || !res.add(step()) {
+			return
+		}
+	}
+}
+
+// lines splits the step's stdout into its non-empty lines.
+func (s gitStep) lines() []string {
+	out := []string{}
+	for _, line := range strings.Split(s.Stdout, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// gitJobs bounds how many git operations run at once; the rest wait for a
+// free slot.
+var gitJobs = newJobQueue(8)
+
+type jobQueue struct {
+	slots   chan struct{}
+	waiting int32
+}
+
+func newJobQueue(size int) *jobQueue {
+	if size < 1 {
+		size = 1
+	}
+	return &jobQueue{slots: make(chan struct{}, size)}
+}
+
+// acquire waits for a free slot, giving up when ctx is done.
+func (q *jobQueue) acquire(ctx context.Context) error {
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	atomic.AddInt32(&q.waiting, 1)
+	defer atomic.AddInt32(&q.waiting, -1)
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *jobQueue) release() {
+	<-q.slots
+}
+
+// usage returns how many slots are taken and how many there are.
+func (q *jobQueue) usage() (int, int) {
+	return len(q.slots), cap(q.slots)
+}
+
+// queued returns how many operations are waiting for a slot.
+func (q *jobQueue) queued() int {
+	return int(atomic.LoadInt32(&q.waiting))
+}
+
+// gitTimeouts limits how long each git subcommand may run; "default"
+// covers any subcommand without an entry of its own. Network operations
+// get longer by default since large repositories are slow to transfer.
+var gitTimeouts = timeouts{
+	"default": 2 * time.Minute,
+	"clone":   30 * time.Minute,
+	"fetch":   10 * time.Minute,
+	"pull":    10 * time.Minute,
+	"push":    10 * time.Minute,
+}
+
+// timeouts is a flag.Value holding durations per git subcommand, written
+// as "clone=30m,push=5m".
+type timeouts map[string]time.Duration
+
+func (t timeouts) String() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + t[name].String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (t timeouts) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		eq := strings.IndexByte(part, '=')
+		if eq <= 0 {
+			return fmt.Errorf("%q is not of the form subcommand=duration", part)
+		}
+		d, err := time.ParseDuration(part[eq+1:])
+		if err != nil {
+			return err
+		}
+		t[strings.TrimSpace(part[:eq])] = d
+	}
+	return nil
+}
+
+// forArgs picks the timeout for a git command line by its subcommand.
+func (t timeouts) forArgs(args []string) time.Duration {
+	if d, ok := t[subcommand(args)]; ok {
+		return d
+	}
+	return t["default"]
+}
+
+// subcommand returns the git subcommand in a command line, skipping global
+// options such as "-c name=value".
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-c" || args[i] == "-C" {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			return args[i]
+		}
+	}
+	return ""
+}
+
+// longest is the largest timeout, which bounds how long any request that
+// runs git may take.
+func (t timeouts) longest() time.Duration {
+	var max time.Duration
+	for _, d := range t {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// runGit runs git with args inside dir and captures its output. It never
+// returns an error of its own; failures are reported through the step.
+// The process and everything it started are killed when ctx is done or
+// the subcommand's timeout passes.
+func runGit(ctx context.Context, dir, step string, args ...string) gitStep {
+	if err := gitJobs.acquire(ctx); err != nil {
+		return gitStep{Step: step, Args: args, ExitCode: -1, Cancelled: true, Error: "cancelled"}
+	}
+	defer gitJobs.release()
+
+	timeout := gitTimeouts.forArgs(args)
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.Command("git", args...)
+	hideWindow(cmd)
+	newProcessGroup(cmd)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), gitEnv()...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	span := gitSpan(ctx, backendExec, dir, args)
+	err := runnerFrom(ctx).Run(runCtx, cmd)
+
+	s := gitStep{
+		Step:   step,
+		Args:   args,
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		s.Cancelled = true
+		s.Error = "cancelled"
+		s.ExitCode = -1
+	case runCtx.Err() != nil:
+		s.TimedOut = true
+		s.Error = "timed out after " + timeout.String()
+		s.ExitCode = -1
+	default:
+		s.Error = err.Error()
+		s.ExitCode = -1
+		if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+			s.ExitCode = exitErr.ExitCode()
+		}
+	}
+	observeGit(s, time.Since(start))
+	endGitSpan(span, s)
+	return s
+}
+
+// gitOutput runs a read-only git query and returns its trimmed stdout, or
+// the empty string when the query fails.
+func gitOutput(ctx context.Context, dir string, args ...string) string {
+	s := runGit(ctx, dir, args[0], args...)
+	if !s.ok() {
+		return ""
+	}
+	return strings.TrimSpace(s.Stdout)
+}
@@ -5,5 +5,6 @@ go 1.14
 require (
 	github.com/cratonica/2goarray v0.0.0-20190331194516-514510793eaa // indirect
 	github.com/cratonica/trayhost v0.0.0-20150112162955-98495206fd96
+	github.com/go-git/go-git/v5 v5.2.0
 	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9 // indirect
 )
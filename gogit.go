@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// goBackend implements GitBackend in pure Go with go-git, for machines
+// without a usable git binary. It only fast-forwards on pull, and as it
+// cannot run the askpass helper it relies on credentials in the remote URL
+// or, for SSH, on a running agent.
+type goBackend struct{}
+
+func (goBackend) Name() string { return backendGo }
+
+// goRun runs fn the way runGit runs git: in a job slot, under the timeout
+// for args and reported as a step whose Args show the equivalent git command.
+func goRun(ctx context.Context, dir, step string, args []string, fn func(ctx context.Context) (string, error)) gitStep {
+	if err := gitJobs.acquire(ctx); err != nil {
+		return gitStep{Step: step, Args: args, ExitCode: -1, Cancelled: true, Error: "cancelled"}
+	}
+	defer gitJobs.release()
+
+	timeout := gitTimeouts.forArgs(args)
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	span := gitSpan(ctx, backendGo, dir, args)
+	out, err := fn(runCtx)
+	s := gitStep{Step: step, Args: args, Stdout: out}
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		s.Cancelled = true
+		s.Error = "cancelled"
+		s.ExitCode = -1
+	case runCtx.Err() != nil:
+		s.TimedOut = true
+		s.Error = "timed out after " + timeout.String()
+		s.ExitCode = -1
+	default:
+		s.Stderr = err.Error()
+		s.Error = err.Error()
+		s.ExitCode = -1
+	}
+	observeGit(s, time.Since(start))
+	endGitSpan(span, s)
+	return s
+}
+
+// openRepo opens the checkout in dir, which may be a linked worktree whose
+// refs live in the main clone's git directory.
+func openRepo(dir string) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+}
+
+// gitDirOf returns the git directory of the checkout in dir. A linked
+// worktree has a .git file pointing at it instead of a .git directory.
+func gitDirOf(dir string) string {
+	dotGit := filepath.Join(dir, ".git")
+	b, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return dotGit
+	}
+	line := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return dotGit
+	}
+	gitDir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return gitDir
+}
+
+// repoName is the directory git clone would create for url.
+func repoName(url string) string {
+	url = strings.TrimSuffix(strings.TrimRight(url, "/\\"), ".git")
+	if i := strings.LastIndexAny(url, "/\\:"); i >= 0 {
+		url = url[i+1:]
+	}
+	return url
+}
+
+func (goBackend) Clone(ctx context.Context, dir, url string) gitStep {
+	return goRun(ctx, dir, "clone", []string{"clone", url}, func(ctx context.Context) (string, error) {
+		path := filepath.Join(dir, repoName(url))
+		if ok, _ := exists(path); ok {
+			return "", fmt.Errorf("destination path '%s' already exists", repoName(url))
+		}
+		if _, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{URL: url}); err != nil {
+			os.RemoveAll(path)
+			return "", err
+		}
+		return "", nil
+	})
+}
+
+// statusCode turns a go-git status into the letter porcelain v2 uses.
+func statusCode(c git.StatusCode) string {
+	if c == git.Unmodified {
+		return "."
+	}
+	return string(c)
+}
+
+func (goBackend) Status(ctx context.Context, dir string) (statusResult, gitStep) {
+	st := statusResult{
+		Conflicts: []string{},
+		Changes:   []fileChange{},
+		Untracked: []string{},
+	}
+	s := goRun(ctx, dir, "status", []string{"status"}, func(ctx context.Context) (string, error) {
+		r, err := openRepo(dir)
+		if err != nil {
+			return "", err
+		}
+		wt, err := r.Worktree()
+		if err != nil {
+			return "", err
+		}
+		files, err := wt.Status()
+		if err != nil {
+			return "", err
+		}
+
+		st.Branch, st.Head = headOf(r)
+		if up, ok := upstreamOf(r, st.Branch); ok {
+			st.Upstream = up.Short()
+			if ref, err := r.Reference(up, true); err == nil && st.Head != "" {
+				head := plumbing.NewHash(st.Head)
+				st.Ahead, _ = countExclusive(r, head, ref.Hash())
+				st.Behind, _ = countExclusive(r, ref.Hash(), head)
+			}
+		}
+
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			f := files[path]
+			switch {
+			case f.Staging == git.Untracked:
+				st.Untracked = append(st.Untracked, path)
+			case f.Staging == git.UpdatedButUnmerged || f.Worktree == git.UpdatedButUnmerged:
+				st.Conflicts = append(st.Conflicts, path)
+			case f.Staging != git.Unmodified || f.Worktree != git.Unmodified:
+				st.Changes = append(st.Changes, fileChange{Path: path, Index: statusCode(f.Staging), WorkTree: statusCode(f.Worktree)})
+			}
+		}
+		st.Operation = operationIn(gitDirOf(dir))
+		return "", nil
+	})
+	return st, s
+}
+
+// headOf returns the checked out branch, "(detached)" when there is none,
+// and the commit HEAD points at, which is empty before the first commit.
+func headOf(r *git.Repository) (branch, hash string) {
+	head, err := r.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		branch = head.Target().Short()
+	} else {
+		branch = "(detached)"
+	}
+	if resolved, err := r.Head(); err == nil {
+		hash = resolved.Hash().String()
+	}
+	return branch, hash
+}
+
+// upstreamOf returns the remote-tracking ref branch is configured to
+// follow.
+func upstreamOf(r *git.Repository, branch string) (plumbing.ReferenceName, bool) {
+	cfg, err := r.Config()
+	if err != nil {
+		return "", false
+	}
+	b, ok := cfg.Branches[branch]
+	if !ok || b.Remote == "" || b.Merge == "" {
+		return "", false
+	}
+	return plumbing.NewRemoteReferenceName(b.Remote, b.Merge.Short()), true
+}
+
+// countExclusive counts the commits reachable from from but not from
+// exclude, like `git rev-list --count exclude..from`.
+func countExclusive(r *git.Repository, from, exclude plumbing.Hash) (int, error) {
+	seen := map[plumbing.Hash]bool{}
+	if !exclude.IsZero() {
+		c, err := r.CommitObject(exclude)
+		if err != nil {
+			return 0, err
+		}
+		err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+			seen[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	c, err := r.CommitObject(from)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = object.NewCommitPreorderIter(c, seen, nil).ForEach(func(c *object.Commit) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func (goBackend) Add(ctx context.Context, dir string, paths []string) gitStep {
+	args := append([]string{"add", "--"}, paths...)
+	if len(paths) == 0 {
+		args = []string{"add", "-A"}
+	}
+	return goRun(ctx, dir, "stage", args, func(ctx context.Context) (string, error) {
+		r, err := openRepo(dir)
+		if err != nil {
+			return "", err
+		}
+		wt, err := r.Worktree()
+		if err != nil {
+			return "", err
+		}
+		if len(paths) == 0 {
+			if err := wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+				return "", err
+			}
+			// go-git's -A leaves deleted files in the index.
+			files, err := wt.Status()
+			if err != nil {
+				return "", err
+			}
+			for path, f := range files {
+				if f.Worktree == git.Deleted {
+					if _, err := wt.Remove(path); err != nil {
+						return "", fmt.Errorf("%s: %v", path, err)
+					}
+				}
+			}
+			return "", nil
+		}
+		for _, path := range paths {
+			if _, err := wt.Add(filepath.ToSlash(path)); err != nil {
+				return "", fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		return "", nil
+	})
+}
+
+// signature reads an identity from the environment variables git itself
+// honours, such as GIT_AUTHOR_NAME and GIT_AUTHOR_EMAIL.
+func signature(role string) *object.Signature {
+	name, email := os.Getenv("GIT_"+role+"_NAME"), os.Getenv("GIT_"+role+"_EMAIL")
+	if name == "" || email == "" {
+		return nil
+	}
+	return &object.Signature{Name: name, Email: email, When: time.Now()}
+}
+
+func (goBackend) Commit(ctx context.Context, dir, message string) gitStep {
+	if message == "" {
+		return gitStep{Step: "commit", ExitCode: -1, Error: "commit message is required"}
+	}
+	return goRun(ctx, dir, "commit", []string{"commit", "-m", message}, func(ctx context.Context) (string, error) {
+		r, err := openRepo(dir)
+		if err != nil {
+			return "", err
+		}
+		wt, err := r.Worktree()
+		if err != nil {
+			return "", err
+		}
+		files, err := wt.Status()
+		if err != nil {
+			return "", err
+		}
+		staged := false
+		for _, f := range files {
+			if f.Staging != git.Unmodified && f.Staging != git.Untracked {
+				staged = true
+				break
+			}
+		}
+		if !staged {
+			return "", errors.New("nothing to commit, working tree clean")
+		}
+		opts := &git.CommitOptions{Author: signature("AUTHOR"), Committer: signature("COMMITTER")}
+		if opts.Author == nil && opts.Committer != nil {
+			opts.Author = opts.Committer
+		}
+		hash, err := wt.Commit(message, opts)
+		if err != nil {
+			if opts.Author == nil && strings.Contains(err.Error(), "author") {
+				return "", errors.New("Author identity unknown; set user.name and user.email")
+			}
+			return "", err
+		}
+		branch, _ := headOf(r)
+		return fmt.Sprintf("[%s %s] %s\n", branch, hash.String()[:7], strings.SplitN(message, "\n", 2)[0]), nil
+	})
+}
+
+func (goBackend) Fetch(ctx context.Context, dir, remote string) gitStep {
+	args := []string{"fetch", remote}
+	if remote == "" {
+		args = []string{"fetch", "--all"}
+	}
+	if strings.HasPrefix(remote, "-") {
+		return gitStep{Step: "fetch", Args: args, ExitCode: -1, Error: "remote must not start with '-'"}
+	}
+	return goRun(ctx, dir, "fetch", args, func(ctx context.Context) (string, error) {
+		r, err := openRepo(dir)
+		if err != nil {
+			return "", err
+		}
+		names := []string{remote}
+		if remote == "" {
+			remotes, err := r.Remotes()
+			if err != nil {
+				return "", err
+			}
+			names = names[:0]
+			for _, rem := range remotes {
+				names = append(names, rem.Config().Name)
+			}
+		}
+		for _, name := range names {
+			err := r.FetchContext(ctx, &git.FetchOptions{RemoteName: name})
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				return "", fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		return "", nil
+	})
+}
+
+func (goBackend) Push(ctx context.Context, dir, remote, branch string) gitStep {
+	if remote == "" {
+		remote = "origin"
+	}
+	args := []string{"push", "-u", remote, branch}
+	if branch == "" {
+		args[3] = "HEAD"
+	}
+	if strings.HasPrefix(remote, "-") || strings.HasPrefix(branch, "-") {
+		return gitStep{Step: "push", Args: args, ExitCode: -1, Error: "remote and branch must not start with '-'"}
+	}
+	return goRun(ctx, dir, "push", args, func(ctx context.Context) (string, error) {
+		r, err := openRepo(dir)
+		if err != nil {
+			return "", err
+		}
+		if branch == "" {
+			if branch, _ = headOf(r); branch == "" || branch == "(detached)" {
+				return "", errors.New("not on a branch")
+			}
+		}
+		ref := plumbing.NewBranchReferenceName(branch)
+		err = r.PushContext(ctx, &git.PushOptions{
+			RemoteName: remote,
+			RefSpecs:   []config.RefSpec{config.RefSpec(ref + ":" + ref)},
+		})
+		out := ""
+		switch {
+		case err == git.NoErrAlreadyUpToDate:
+			out = "Everything up-to-date\n"
+		case err != nil:
+			return "", err
+		}
+
+		// Like -u, remember the remote branch as the upstream.
+		cfg, err := r.Config()
+		if err != nil {
+			return out, err
+		}
+		cfg.Branches[branch] = &config.Branch{Name: branch, Remote: remote, Merge: ref}
+		return out, r.SetConfig(cfg)
+	})
+}
+
+// Pull fetches and fast-forwards. go-git cannot merge or rebase, so any
+// other strategy fails without touching the repository.
+func (goBackend) Pull(ctx context.Context, dir string, msg pullRequest) pullResult {
+	res := pullResult{
+		gitResult:       newGitResult(),
+		FilesChanged:    []string{},
+		ConflictedFiles: []string{},
+	}
+	args, _ := pullArgs(msg)
+	if (msg.Strategy != "" && msg.Strategy != pullFastForward) || msg.Autostash || msg.RecurseSubmodules {
+		res.add(gitStep{Step: "pull", Args: args, ExitCode: -1, Error: "the built-in git backend can only fast-forward"})
+		return res
+	}
+
+	res.add(goRun(ctx, dir, "pull", args, func(ctx context.Context) (string, error) {
+		r, err := openRepo(dir)
+		if err != nil {
+			return "", err
+		}
+		wt, err := r.Worktree()
+		if err != nil {
+			return "", err
+		}
+		branch, old := headOf(r)
+		res.OldHead, res.NewHead = old, old
+
+		opts := &git.PullOptions{RemoteName: msg.Remote}
+		if msg.Branch != "" {
+			opts.ReferenceName = plumbing.NewBranchReferenceName(msg.Branch)
+		} else if up, ok := upstreamOf(r, branch); ok {
+			cfg, _ := r.Config()
+			opts.ReferenceName = cfg.Branches[branch].Merge
+			if opts.RemoteName == "" {
+				opts.RemoteName = strings.SplitN(up.Short(), "/", 2)[0]
+			}
+		} else if branch != "" && branch != "(detached)" {
+			opts.ReferenceName = plumbing.NewBranchReferenceName(branch)
+		}
+		err = wt.PullContext(ctx, opts)
+		switch {
+		case err == git.NoErrAlreadyUpToDate:
+			return "Already up to date.\n", nil
+		case err == git.ErrNonFastForwardUpdate:
+			return "", errors.New("Not possible to fast-forward, aborting.")
+		case err != nil:
+			return "", err
+		}
+
+		_, res.NewHead = headOf(r)
+		if res.NewHead == res.OldHead {
+			return "", nil
+		}
+		newHead := plumbing.NewHash(res.NewHead)
+		oldHead := plumbing.ZeroHash
+		if old != "" {
+			oldHead = plumbing.NewHash(old)
+		}
+		res.CommitsPulled, _ = countExclusive(r, newHead, oldHead)
+		res.FilesChanged, err = changedFiles(r, oldHead, newHead)
+		return "", err
+	}))
+	return res
+}
+
+// changedFiles lists the paths that differ between two commits; from may
+// be zero to list every file in to.
+func changedFiles(r *git.Repository, from, to plumbing.Hash) ([]string, error) {
+	treeOf := func(h plumbing.Hash) (*object.Tree, error) {
+		if h.IsZero() {
+			return nil, nil
+		}
+		c, err := r.CommitObject(h)
+		if err != nil {
+			return nil, err
+		}
+		return c.Tree()
+	}
+	fromTree, err := treeOf(from)
+	if err != nil {
+		return []string{}, err
+	}
+	toTree, err := treeOf(to)
+	if err != nil {
+		return []string{}, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return []string{}, err
+	}
+	files := []string{}
+	for _, ch := range changes {
+		name := ch.To.Name
+		if name == "" {
+			name = ch.From.Name
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -1,23 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
-	"syscall"
-
+	"time"
 )
 
 func setupResponse(w *http.ResponseWriter, req *http.Request) {
 	(*w).Header().Set("Access-Control-Allow-Origin", "*")
 	(*w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Request-Id, traceparent")
+	(*w).Header().Set("Access-Control-Expose-Headers", "X-Request-Id, traceparent")
 }
 
 func index() http.Handler {
@@ -44,6 +45,45 @@ type gitData struct {
 	ProjectName string `json:"ProjectName"`
 	RootPath    string `json:"RootPath"`
 	GitMsg      string `json:"GitMsg"`
+	Worktree    string `json:"Worktree"`
+}
+
+// repoPath is where the repository described by d is checked out.
+func (d gitData) repoPath() string {
+	return filepath.Join(d.RootPath, d.Domain, d.GitUserName, d.ProjectName)
+}
+
+// within reports whether path is root or lies underneath it.
+func within(root, path string) bool {
+	inside, err := filepath.Rel(root, path)
+	return err == nil && inside != ".." && !strings.HasPrefix(inside, ".."+string(filepath.Separator))
+}
+
+// repoFile resolves the repo-relative path rel inside repoPath, refusing
+// anything that would escape the repository, including through symlinks.
+func repoFile(repoPath, rel string) (string, error) {
+	if rel == "" || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path %q must be relative to the repository", rel)
+	}
+	full := filepath.Join(repoPath, rel)
+	if !within(repoPath, full) {
+		return "", fmt.Errorf("path %q is outside the repository", rel)
+	}
+	realRoot, rootErr := filepath.EvalSymlinks(repoPath)
+	realFull, fullErr := filepath.EvalSymlinks(full)
+	if rootErr == nil && fullErr == nil && !within(realRoot, realFull) {
+		return "", fmt.Errorf("path %q is outside the repository", rel)
+	}
+	return full, nil
+}
+
+// checkoutPath is the working directory to use for d: its linked worktree
+// when Worktree names one, otherwise the main clone.
+func (d gitData) checkoutPath() string {
+	if d.Worktree == "" {
+		return d.repoPath()
+	}
+	return worktreePath(d.repoPath(), d.Worktree)
 }
 
 type repoStatus struct {
@@ -61,221 +101,137 @@ func exists(path string) (bool, error) {
 	return false, err
 }
 
-func repoExists() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		setupResponse(&w, r)
-		if (*r).Method == "OPTIONS" {
-			return
-		}
-		logger := log.New(os.Stdout, "http: ", log.LstdFlags)
-
-		if r.Method == "POST" {
-			decoder := json.NewDecoder(r.Body)
-			var msg gitData
-			err := decoder.Decode(&msg)
-			if err != nil {
-				panic(err)
-			}
-
-			repoRoot := msg.RootPath
-			repoBase := filepath.Join(repoRoot, msg.Domain, msg.GitUserName)
-			repoPath := filepath.Join(repoBase, msg.ProjectName)
-			logger.Println("repoPath", repoPath)
-
-			if repoExist, _ := exists(repoPath); repoExist {
-				logger.Println("repo exist")
-				repoRes := repoStatus{true}
-
-				js, err := json.Marshal(repoRes)
-				if err != nil {
-					panic(err)
-				}
-				w.Header().Set("Content-Type", "application/json")
-				w.Write(js)
-			} else {
-				logger.Println("repo not exist")
-				repoRes := repoStatus{false}
-
-				js, err := json.Marshal(repoRes)
-				if err != nil {
-					panic(err)
-				}
-				w.Header().Set("Content-Type", "application/json")
-				w.Write(js)
-			}
-
-		}
-	})
+// decodeRequest enforces POST and decodes the JSON body into v. It writes the
+// error response itself and reports whether the handler should continue.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
 }
 
-func gitClone() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		setupResponse(&w, r)
-		if (*r).Method == "OPTIONS" {
-			return
-		}
-		logger := log.New(os.Stdout, "http: ", log.LstdFlags)
-
-		if r.Method == "POST" {
-
-			decoder := json.NewDecoder(r.Body)
-			var msg gitData
-			err := decoder.Decode(&msg)
-			if err != nil {
-				panic(err)
-			}
-
-			repoRoot := msg.RootPath
-			repoBase := filepath.Join(repoRoot, msg.Domain, msg.GitUserName)
-			logger.Println("Repo Path", repoBase)
-
-			if _, err := os.Stat(repoBase); os.IsNotExist(err) {
-				logger.Println("Not exist creating")
-				os.MkdirAll(repoBase, os.ModePerm)
-
-			}
-			cmd := exec.Command("git", "clone", msg.RepoURL)
-			cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000} // CREATE_NO_WINDOW
-			cmd.Dir = repoBase
-
-			_, err = cmd.Output()
+// rejectOption answers 400 and reports true when value, which is passed to
+// git as a positional argument, starts with '-' and would be read as an
+// option.
+func rejectOption(w http.ResponseWriter, field, value string) bool {
+	if !strings.HasPrefix(value, "-") {
+		return false
+	}
+	http.Error(w, field+" must not start with '-'", http.StatusBadRequest)
+	return true
+}
 
-			if err != nil {
-				logger.Println("Git Clone error", err)
-				// panic(err)
-			}
+// writeJSON sends v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
 
-			output, err := json.Marshal(msg)
-			if err != nil {
-				http.Error(w, err.Error(), 500)
-				panic(err)
-			}
-			w.Header().Set("content-type", "application/json")
-			w.Write(output)
+// resultStatus is the HTTP status for res: 504 when a step timed out and
+// 500 when one failed.
+func resultStatus(res *gitResult) int {
+	switch {
+	case res.TimedOut:
+		return http.StatusGatewayTimeout
+	case !res.Success:
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
 
-		}
-	})
+// writeResult sends res with the status matching its outcome.
+func writeResult(w http.ResponseWriter, res *gitResult) {
+	writeJSON(w, resultStatus(res), res)
 }
 
-func openVsCode() http.Handler {
+func repoExists() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		setupResponse(&w, r)
 		if (*r).Method == "OPTIONS" {
 			return
 		}
-		logger := log.New(os.Stdout, "http: ", log.LstdFlags)
-
-		decoder := json.NewDecoder(r.Body)
 		var msg gitData
-		err := decoder.Decode(&msg)
-		if err != nil {
-			panic(err)
-		}
-
-		repoRoot := msg.RootPath
-		repoPath := filepath.Join(repoRoot, msg.Domain, msg.GitUserName, msg.ProjectName)
-		cmd := exec.Command("code", repoPath)
-		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000} // CREATE_NO_WINDOW
-
-		stdout, err := cmd.Output()
-
-		if err != nil {
-			logger.Println(err.Error())
+		if !decodeRequest(w, r, &msg) {
 			return
 		}
-
-		logger.Println(string(stdout))
+		repoPath := msg.checkoutPath()
+		repoExist, _ := exists(repoPath)
+		logger.Debug("checked for repository", "path", repoPath, "exists", repoExist)
+		writeJSON(w, http.StatusOK, repoStatus{repoExist})
 	})
 }
 
-func gitPush() http.Handler {
+// gitClone clones RepoURL under RootPath/Domain/GitUserName and echoes the
+// request back, with a 500 status when the clone failed.
+func gitClone() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		setupResponse(&w, r)
 		if (*r).Method == "OPTIONS" {
 			return
 		}
-		logger := log.New(os.Stdout, "http: ", log.LstdFlags)
-
-		decoder := json.NewDecoder(r.Body)
 		var msg gitData
-		err := decoder.Decode(&msg)
-		if err != nil {
-			panic(err)
+		if !decodeRequest(w, r, &msg) {
+			return
 		}
-
-		repoRoot := msg.RootPath
-		repoPath := filepath.Join(repoRoot, msg.Domain, msg.GitUserName, msg.ProjectName)
-
-		logger.Println("git add . in gitPush()")
-		cmd := exec.Command("git", "add", ".")
-		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000} // CREATE_NO_WINDOW
-
-		cmd.Dir = repoPath
-		stdout, err := cmd.Output()
-
-		if err != nil {
-			logger.Println(err.Error())
-			// return // Gives exit status 128 error but works !!
+		if msg.Worktree != "" {
+			http.Error(w, "Worktree is not supported when cloning", http.StatusBadRequest)
+			return
 		}
-		logger.Println(string(stdout))
+		repoBase := filepath.Join(msg.RootPath, msg.Domain, msg.GitUserName)
+		logger.Info("cloning", "url", msg.RepoURL, "into", repoBase)
 
-		commitMsg := msg.GitMsg
-
-		cmd = exec.Command("git", "commit", "-m", commitMsg)
-		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000} // CREATE_NO_WINDOW
-		cmd.Dir = repoPath
-		stdout, err = cmd.Output()
-
-		if err != nil {
-			logger.Println(err.Error())
-			// return
+		if _, err := os.Stat(repoBase); os.IsNotExist(err) {
+			logger.Debug("creating directory", "path", repoBase)
+			os.MkdirAll(repoBase, os.ModePerm)
 		}
-		logger.Println(string(stdout))
-
-		cmd = exec.Command("git", "push", "-u", "origin", "master")
-		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000} // CREATE_NO_WINDOW
-		cmd.Dir = repoPath
-		stdout, err = cmd.Output()
-
-		if err != nil {
-			logger.Println(err.Error())
-			// return
+		status := http.StatusOK
+		if s := backend.Clone(r.Context(), repoBase, msg.RepoURL); !s.ok() {
+			logger.Error("clone failed", "url", msg.RepoURL, "reason", s.reason())
+			status = http.StatusInternalServerError
 		}
-		logger.Println(string(stdout))
+		writeJSON(w, status, msg)
 	})
 }
 
-func gitPull() http.Handler {
+// openEditor opens path in VS Code.
+func openEditor(ctx context.Context, path string) ([]byte, error) {
+	cmd := exec.Command("code", path)
+	hideWindow(cmd)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := runnerFrom(ctx).Run(ctx, cmd)
+	return stdout.Bytes(), err
+}
+
+func openVsCode() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		setupResponse(&w, r)
 		if (*r).Method == "OPTIONS" {
 			return
 		}
-		logger := log.New(os.Stdout, "http: ", log.LstdFlags)
-
-		decoder := json.NewDecoder(r.Body)
 		var msg gitData
-		err := decoder.Decode(&msg)
-		if err != nil {
-			panic(err)
+		if !decodeRequest(w, r, &msg) {
+			return
 		}
-
-		repoRoot := msg.RootPath
-		repoPath := filepath.Join(repoRoot, msg.Domain, msg.GitUserName, msg.ProjectName)
-
-		logger.Println("In gitPull")
-		cmd := exec.Command("git", "pull")
-		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: 0x08000000} // CREATE_NO_WINDOW
-
-		cmd.Dir = repoPath
-		stdout, err := cmd.Output()
-
+		stdout, err := openEditor(r.Context(), msg.checkoutPath())
 		if err != nil {
-			logger.Println(err.Error())
-			// return // Gives exit status 128 error but works !!
+			logger.Error("could not open VS Code", "path", msg.checkoutPath(), "err", err)
+			http.Error(w, "could not open VS Code: "+err.Error(), http.StatusInternalServerError)
+			return
 		}
-		logger.Println(string(stdout))
 
+		logger.Debug("VS Code started", "output", string(stdout))
 	})
 }
 
@@ -293,39 +249,83 @@ func healthz() http.Handler {
 	})
 }
 
-func logging(logger *log.Logger) func(http.Handler) http.Handler {
+func logging(logger *leveledLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			setupResponse(&w, r)
 			if (*r).Method == "OPTIONS" {
 				return
 			}
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
 			defer func() {
+				if rec.status == 0 {
+					rec.status = http.StatusOK // nothing written
+				}
+				if rec.status < http.StatusBadRequest && !accessSampling.sampled(r.URL.Path) {
+					return
+				}
 				requestID, ok := r.Context().Value(requestIDKey).(string)
 				if !ok {
 					requestID = "unknown"
 				}
-				logger.Println(requestID, r.Method, r.URL.Path, r.RemoteAddr)
+				writeAccessEntry(logger, accessEntry{
+					Time:       start,
+					RequestID:  requestID,
+					RemoteAddr: r.RemoteAddr,
+					Method:     r.Method,
+					Path:       r.URL.Path,
+					Proto:      r.Proto,
+					Status:     rec.status,
+					Bytes:      rec.bytes,
+					DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+				})
 			}()
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(rec, r)
 		})
 	}
 }
 
-func tracing(nextRequestID func() string) func(http.Handler) http.Handler {
+// tracing starts a server span for each request, continuing the caller's
+// trace when it sends a W3C traceparent header. The request ID is taken
+// from X-Request-Id for callers that only know that header, and otherwise
+// is the trace ID, so log lines and spans can be matched up.
+func tracing(t *tracer) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			setupResponse(&w, r)
 			if (*r).Method == "OPTIONS" {
 				return
 			}
+			ctx := r.Context()
+			if parent, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+				ctx = context.WithValue(ctx, spanKey, parent)
+			}
+			ctx, span := t.start(ctx, r.Method+" "+r.URL.Path, spanServer)
 			requestID := r.Header.Get("X-Request-Id")
 			if requestID == "" {
-				requestID = nextRequestID()
+				requestID = span.TraceID
 			}
-			ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+			ctx = context.WithValue(ctx, requestIDKey, requestID)
 			w.Header().Set("X-Request-Id", requestID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			w.Header().Set("traceparent", span.traceparent())
+
+			span.set("http.request.method", r.Method)
+			span.set("url.path", r.URL.Path)
+			span.set("client.address", r.RemoteAddr)
+			span.set("request.id", requestID)
+			rec := &responseRecorder{ResponseWriter: w}
+			defer func() {
+				if rec.status == 0 {
+					rec.status = http.StatusOK
+				}
+				span.set("http.response.status_code", rec.status)
+				if rec.status >= http.StatusInternalServerError {
+					span.fail(http.StatusText(rec.status))
+				}
+				span.end()
+			}()
+			next.ServeHTTP(rec, r.WithContext(ctx))
 		})
 	}
 }
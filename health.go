@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// stringList is a flag.Value collecting every use of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// workspaceRoots are the RootPath directories the extension clones into,
+// given with -root. Readiness requires each of them to be writable.
+var workspaceRoots stringList
+
+type probe struct {
+	Name   string `json:"Name"`
+	OK     bool   `json:"OK"`
+	Detail string `json:"Detail,omitempty"`
+}
+
+type readiness struct {
+	Ready  bool    `json:"Ready"`
+	Probes []probe `json:"Probes"`
+}
+
+func serverProbe() probe {
+	if atomic.LoadInt32(&healthy) == 1 {
+		return probe{Name: "server", OK: true}
+	}
+	return probe{Name: "server", Detail: "starting or shutting down"}
+}
+
+func gitProbe(ctx context.Context) probe {
+	if backend.Name() == backendGo {
+		return probe{Name: "git", OK: true, Detail: "built-in backend"}
+	}
+	version, err := installedGitVersion(ctx)
+	if err != nil {
+		return probe{Name: "git", Detail: err.Error()}
+	}
+	return probe{Name: "git", OK: true, Detail: version}
+}
+
+// rootProbe checks that a file can be created in root.
+func rootProbe(root string) probe {
+	p := probe{Name: "root " + root}
+	f, err := ioutil.TempFile(root, ".gitify-probe-")
+	if err != nil {
+		p.Detail = err.Error()
+		return p
+	}
+	f.Close()
+	os.Remove(f.Name())
+	p.OK = true
+	return p
+}
+
+func jobsProbe() probe {
+	used, size := gitJobs.usage()
+	return probe{
+		Name:   "jobs",
+		OK:     used < size,
+		Detail: strconv.Itoa(used) + " of " + strconv.Itoa(size) + " git jobs running",
+	}
+}
+
+// livez answers as long as the server can handle requests at all.
+func livez() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// readyz reports whether the server can take git work right now, with
+// the outcome of each probe. It is 503 while any probe fails.
+func readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		res := readiness{Ready: true, Probes: []probe{serverProbe(), gitProbe(r.Context()), jobsProbe()}}
+		for _, root := range workspaceRoots {
+			res.Probes = append(res.Probes, rootProbe(root))
+		}
+		for _, p := range res.Probes {
+			res.Ready = res.Ready && p.OK
+		}
+		status := http.StatusOK
+		if !res.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, res)
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLogLimit = 50
+	maxLogLimit     = 500
+)
+
+type logRequest struct {
+	gitData
+	Branch string `json:"Branch"`
+	Path   string `json:"Path"`
+	Author string `json:"Author"`
+	Since  string `json:"Since"`
+	Until  string `json:"Until"`
+	Cursor string `json:"Cursor"`
+	Limit  int    `json:"Limit"`
+}
+
+type commitInfo struct {
+	Hash           string   `json:"Hash"`
+	Parents        []string `json:"Parents"`
+	AuthorName     string   `json:"AuthorName"`
+	AuthorEmail    string   `json:"AuthorEmail"`
+	AuthorDate     string   `json:"AuthorDate"`
+	CommitterName  string   `json:"CommitterName"`
+	CommitterEmail string   `json:"CommitterEmail"`
+	CommitDate     string   `json:"CommitDate"`
+	Subject        string   `json:"Subject"`
+	Body           string   `json:"Body"`
+	Refs           []string `json:"Refs"`
+	Unpushed       bool     `json:"Unpushed"`
+}
+
+type logResult struct {
+	Commits    []commitInfo `json:"Commits"`
+	Upstream   string       `json:"Upstream"`
+	NextCursor string       `json:"NextCursor"`
+}
+
+// commitFormat separates fields with NUL and ends each record with the
+// ASCII record separator, since bodies span several lines.
+const commitFormat = "%H%x00%P%x00%an%x00%ae%x00%aI%x00%cn%x00%ce%x00%cI%x00%D%x00%s%x00%b%x1e"
+
+// parseCursor splits a cursor of the form "<commit>.<skip>". Pinning the
+// first page's tip commit keeps later pages stable when new commits land.
+func parseCursor(cursor string) (string, int, error) {
+	dot := strings.LastIndexByte(cursor, '.')
+	if dot <= 0 || strings.Trim(cursor[:dot], "0123456789abcdef") != "" {
+		return "", 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	skip, err := strconv.Atoi(cursor[dot+1:])
+	if err != nil || skip < 0 {
+		return "", 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return cursor[:dot], skip, nil
+}
+
+func parseCommits(out string) []commitInfo {
+	commits := []commitInfo{}
+	for _, record := range strings.Split(out, "\x1e") {
+		f := strings.Split(strings.TrimLeft(record, "\r\n"), "\x00")
+		if len(f) != 11 {
+			continue
+		}
+		c := commitInfo{
+			Hash:           f[0],
+			Parents:        strings.Fields(f[1]),
+			AuthorName:     f[2],
+			AuthorEmail:    f[3],
+			AuthorDate:     f[4],
+			CommitterName:  f[5],
+			CommitterEmail: f[6],
+			CommitDate:     f[7],
+			Subject:        f[9],
+			Body:           strings.TrimSpace(f[10]),
+			Refs:           []string{},
+		}
+		if f[8] != "" {
+			c.Refs = strings.Split(f[8], ", ")
+		}
+		commits = append(commits, c)
+	}
+	return commits
+}
+
+// unpushedCommits returns which of the first window commits reachable from
+// tip are not on the upstream of branch, or on any remote when there is no
+// upstream. filter and pathspec are the log's own: unpushed commits come
+// in the same order as the log, so those on a page ending at window are all
+// among the first window of them.
+func unpushedCommits(ctx context.Context, dir, branch, tip string, window int, filter, pathspec []string) (string, map[string]bool) {
+	upstream := gitOutput(ctx, dir, "rev-parse", "--abbrev-ref", "--symbolic-full-name", branch+"@{upstream}")
+	args := []string{"rev-list", "--max-count=" + strconv.Itoa(window)}
+	args = append(args, filter...)
+	args = append(args, tip)
+	if upstream != "" {
+		args = append(args, "^"+upstream)
+	} else {
+		args = append(args, "--not", "--remotes")
+	}
+	args = append(args, pathspec...)
+	set := map[string]bool{}
+	for _, hash := range runGit(ctx, dir, "unpushed", args...).lines() {
+		set[hash] = true
+	}
+	return upstream, set
+}
+
+func gitLog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg logRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		dir := msg.checkoutPath()
+		if msg.Branch == "" {
+			msg.Branch = "HEAD"
+		}
+		if strings.HasPrefix(msg.Branch, "-") {
+			http.Error(w, "invalid Branch", http.StatusBadRequest)
+			return
+		}
+		if msg.Limit <= 0 {
+			msg.Limit = defaultLogLimit
+		}
+		if msg.Limit > maxLogLimit {
+			msg.Limit = maxLogLimit
+		}
+
+		tip, skip := "", 0
+		if msg.Cursor != "" {
+			var err error
+			if tip, skip, err = parseCursor(msg.Cursor); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if tip = gitOutput(ctx, dir, "rev-parse", "--verify", "-q", msg.Branch+"^{commit}"); tip == "" {
+			http.Error(w, "unknown branch "+strconv.Quote(msg.Branch), http.StatusNotFound)
+			return
+		}
+
+		var filter, pathspec []string
+		if msg.Author != "" {
+			filter = append(filter, "--author="+msg.Author)
+		}
+		if msg.Since != "" {
+			filter = append(filter, "--since="+msg.Since)
+		}
+		if msg.Until != "" {
+			filter = append(filter, "--until="+msg.Until)
+		}
+		if msg.Path != "" {
+			if _, err := repoFile(dir, msg.Path); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			pathspec = []string{"--", filepath.ToSlash(msg.Path)}
+		}
+
+		// Ask for one extra commit to learn whether another page exists.
+		args := []string{"log", "--format=" + commitFormat, "--max-count=" + strconv.Itoa(msg.Limit+1), "--skip=" + strconv.Itoa(skip)}
+		args = append(append(append(args, filter...), tip), pathspec...)
+
+		s := runGit(ctx, dir, "log", args...)
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+
+		res := logResult{Commits: parseCommits(s.Stdout)}
+		if len(res.Commits) > msg.Limit {
+			res.Commits = res.Commits[:msg.Limit]
+			res.NextCursor = tip + "." + strconv.Itoa(skip+msg.Limit)
+		}
+		var unpushed map[string]bool
+		res.Upstream, unpushed = unpushedCommits(ctx, dir, msg.Branch, tip, skip+len(res.Commits), filter, pathspec)
+		for i := range res.Commits {
+			res.Commits[i].Unpushed = unpushed[res.Commits[i].Hash]
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
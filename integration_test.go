@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The integration tests drive the real server and git against bare
+// repositories on disk, reached through file:// URLs. They are skipped
+// with -short or when git is not installed.
+
+// gitEnvironment isolates git from the user's configuration and gives it
+// an identity to commit with.
+var gitEnvironment = map[string]string{
+	"GIT_CONFIG_NOSYSTEM": "1",
+	"GIT_AUTHOR_NAME":     "Alice",
+	"GIT_AUTHOR_EMAIL":    "alice@example.com",
+	"GIT_COMMITTER_NAME":  "Alice",
+	"GIT_COMMITTER_EMAIL": "alice@example.com",
+}
+
+type integration struct {
+	t    *testing.T
+	tmp  string
+	url  string
+	root string
+}
+
+func newIntegration(t *testing.T) *integration {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tmp, err := ioutil.TempDir("", "gitify-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := &integration{t: t, tmp: tmp, root: filepath.Join(tmp, "root")}
+
+	env := map[string]string{"HOME": tmp, "USERPROFILE": tmp, "XDG_CONFIG_HOME": tmp}
+	for k, v := range gitEnvironment {
+		env[k] = v
+	}
+	for k, v := range env {
+		old, had := os.LookupEnv(k)
+		os.Setenv(k, v)
+		defer func(k, old string, had bool) {
+			if had {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		}(k, old, had)
+	}
+	return it
+}
+
+// start runs the real server on an ephemeral port until the returned
+// function is called.
+func (it *integration) start() func() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		it.t.Fatal(err)
+	}
+	srv := newServer(newLeveledLogger(ioutil.Discard, levelError, logText))
+	go srv.Serve(l)
+	it.url = "http://" + l.Addr().String()
+	return func() { srv.Close() }
+}
+
+// git runs git in dir for test setup and inspection.
+func (it *integration) git(dir string, args ...string) string {
+	it.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		it.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (it *integration) write(path, content string) {
+	it.t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		it.t.Fatal(err)
+	}
+}
+
+func (it *integration) read(path string) string {
+	it.t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		it.t.Fatal(err)
+	}
+	return string(b)
+}
+
+// bareRepo creates name.git with one commit on main and returns its URL.
+func (it *integration) bareRepo(name string) (string, string) {
+	bare := filepath.Join(it.tmp, "remotes", name+".git")
+	seed := filepath.Join(it.tmp, "seed-"+name)
+	for _, dir := range []string{bare, seed} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			it.t.Fatal(err)
+		}
+	}
+	it.git(bare, "init", "-q", "--bare")
+	it.git(bare, "symbolic-ref", "HEAD", "refs/heads/main")
+	it.git(seed, "init", "-q")
+	it.write(filepath.Join(seed, "README.md"), "# "+name+"\n")
+	it.git(seed, "add", "README.md")
+	it.git(seed, "commit", "-q", "-m", "Initial commit")
+	it.git(seed, "push", "-q", bare, "HEAD:refs/heads/main")
+	return bare, fileURL(bare)
+}
+
+func fileURL(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // C:/... on Windows
+	}
+	return "file://" + path
+}
+
+// post sends fields, merged over the repository identity, and decodes the
+// JSON response into out when it is not nil.
+func (it *integration) post(path string, fields map[string]interface{}, out interface{}) int {
+	it.t.Helper()
+	body := map[string]interface{}{
+		"RootPath":    it.root,
+		"Domain":      "example.com",
+		"GitUserName": "alice",
+		"ProjectName": "demo",
+	}
+	for k, v := range fields {
+		body[k] = v
+	}
+	js, _ := json.Marshal(body)
+	resp, err := http.Post(it.url+path, "application/json", bytes.NewReader(js))
+	if err != nil {
+		it.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			it.t.Fatalf("%s: decoding %q: %v", path, b, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestCloneCommitPushPull(t *testing.T) {
+	for _, b := range []GitBackend{execBackend{}, goBackend{}} {
+		t.Run(b.Name(), func(t *testing.T) {
+			defer func(old GitBackend) { backend = old }(backend)
+			backend = b
+			cloneCommitPushPull(t)
+		})
+	}
+}
+
+func cloneCommitPushPull(t *testing.T) {
+	it := newIntegration(t)
+	defer os.RemoveAll(it.tmp)
+	defer it.start()()
+
+	bare, url := it.bareRepo("demo")
+	clone := filepath.Join(it.root, "example.com", "alice", "demo")
+
+	var exist repoStatus
+	if it.post("/repoExists", nil, &exist); exist.Exist {
+		t.Fatal("repository exists before it was cloned")
+	}
+	if code := it.post("/gitClone", map[string]interface{}{"RepoURL": url}, nil); code != http.StatusOK {
+		t.Fatalf("/gitClone: status %d", code)
+	}
+	if it.post("/repoExists", nil, &exist); !exist.Exist {
+		t.Fatal("/repoExists does not see the clone")
+	}
+	if got := it.read(filepath.Join(clone, "README.md")); got != "# demo\n" {
+		t.Fatalf("cloned README.md = %q", got)
+	}
+
+	// Edit, then commit and push through the API.
+	it.write(filepath.Join(clone, "README.md"), "# demo\n\nEdited in the browser.\n")
+	var st statusResult
+	it.post("/status", nil, &st)
+	if st.Branch != "main" || len(st.Changes) != 1 || st.Changes[0].Path != "README.md" {
+		t.Fatalf("/status = %+v, want README.md modified on main", st)
+	}
+	res := newGitResult()
+	if code := it.post("/gitPush", map[string]interface{}{"GitMsg": "Edit README"}, res); code != http.StatusOK {
+		t.Fatalf("/gitPush: status %d: %+v", code, res)
+	}
+	if got := it.git(bare, "log", "-1", "--format=%s", "main"); got != "Edit README" {
+		t.Errorf("remote main is at %q, want the pushed commit", got)
+	}
+	if got := it.git(bare, "show", "main:README.md"); !strings.Contains(got, "Edited in the browser.") {
+		t.Errorf("remote README.md = %q", got)
+	}
+
+	// Someone else pushes; pull brings their commit in.
+	other := filepath.Join(it.tmp, "other")
+	it.git(it.tmp, "clone", "-q", url, other)
+	it.write(filepath.Join(other, "CONTRIBUTING.md"), "Be nice.\n")
+	it.git(other, "add", "CONTRIBUTING.md")
+	it.git(other, "commit", "-q", "-m", "Add contributing guide")
+	it.git(other, "push", "-q", "origin", "main")
+
+	pulled := pullResult{gitResult: newGitResult()}
+	if code := it.post("/gitPull", nil, &pulled); code != http.StatusOK {
+		t.Fatalf("/gitPull: status %d: %+v", code, pulled)
+	}
+	if pulled.CommitsPulled != 1 || len(pulled.FilesChanged) != 1 || pulled.FilesChanged[0] != "CONTRIBUTING.md" {
+		t.Errorf("/gitPull = %+v, want one commit adding CONTRIBUTING.md", pulled)
+	}
+	if got := it.read(filepath.Join(clone, "CONTRIBUTING.md")); got != "Be nice.\n" {
+		t.Errorf("pulled CONTRIBUTING.md = %q", got)
+	}
+	if local, remote := it.git(clone, "rev-parse", "HEAD"), it.git(bare, "rev-parse", "main"); local != remote {
+		t.Errorf("clone is at %s, remote main at %s", local, remote)
+	}
+
+	var history logResult
+	it.post("/log", nil, &history)
+	var subjects []string
+	for _, c := range history.Commits {
+		subjects = append(subjects, c.Subject)
+	}
+	if want := "Add contributing guide,Edit README,Initial commit"; strings.Join(subjects, ",") != want {
+		t.Errorf("/log subjects = %q, want %q", subjects, want)
+	}
+}
+
+func TestPullConflict(t *testing.T) {
+	it := newIntegration(t)
+	defer os.RemoveAll(it.tmp)
+	defer it.start()()
+
+	_, url := it.bareRepo("demo")
+	clone := filepath.Join(it.root, "example.com", "alice", "demo")
+	it.post("/gitClone", map[string]interface{}{"RepoURL": url}, nil)
+
+	other := filepath.Join(it.tmp, "other")
+	it.git(it.tmp, "clone", "-q", url, other)
+	it.write(filepath.Join(other, "README.md"), "theirs\n")
+	it.git(other, "commit", "-q", "-am", "Theirs")
+	it.git(other, "push", "-q", "origin", "main")
+
+	it.write(filepath.Join(clone, "README.md"), "ours\n")
+	it.post("/stage", map[string]interface{}{"Paths": []string{"README.md"}}, nil)
+	if code := it.post("/commit", map[string]interface{}{"GitMsg": "Ours"}, nil); code != http.StatusOK {
+		t.Fatalf("/commit: status %d", code)
+	}
+
+	pulled := pullResult{gitResult: newGitResult()}
+	if code := it.post("/gitPull", map[string]interface{}{"Strategy": "merge"}, &pulled); code != http.StatusConflict {
+		t.Fatalf("/gitPull: status %d, want 409: %+v", code, pulled)
+	}
+	if len(pulled.ConflictedFiles) != 1 || pulled.ConflictedFiles[0] != "README.md" {
+		t.Errorf("ConflictedFiles = %q, want [README.md]", pulled.ConflictedFiles)
+	}
+
+	res := newGitResult()
+	if code := it.post("/resolveConflict", map[string]interface{}{"Path": "README.md", "Content": "ours and theirs\n"}, res); code != http.StatusOK {
+		t.Fatalf("/resolveConflict: status %d: %+v", code, res)
+	}
+	if code := it.post("/continueMerge", nil, res); code != http.StatusOK {
+		t.Fatalf("/continueMerge: status %d: %+v", code, res)
+	}
+	if got := it.git(clone, "log", "-1", "--format=%p"); len(strings.Fields(got)) != 2 {
+		t.Errorf("HEAD has parents %q, want a merge commit", got)
+	}
+	if got := it.read(filepath.Join(clone, "README.md")); got != "ours and theirs\n" {
+		t.Errorf("README.md = %q after resolving", got)
+	}
+}
+
+func TestLogUnpushed(t *testing.T) {
+	it := newIntegration(t)
+	defer os.RemoveAll(it.tmp)
+	defer it.start()()
+
+	_, url := it.bareRepo("demo")
+	clone := filepath.Join(it.root, "example.com", "alice", "demo")
+	it.post("/gitClone", map[string]interface{}{"RepoURL": url}, nil)
+	for _, name := range []string{"a", "b"} {
+		it.write(filepath.Join(clone, name), name+"\n")
+		it.git(clone, "add", name)
+		it.git(clone, "commit", "-q", "-m", "Add "+name)
+	}
+
+	var got []string
+	cursor := ""
+	for {
+		var page logResult
+		it.post("/log", map[string]interface{}{"Limit": 1, "Cursor": cursor}, &page)
+		for _, c := range page.Commits {
+			got = append(got, c.Subject+"="+strconv.FormatBool(c.Unpushed))
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if want := "Add b=true,Add a=true,Initial commit=false"; strings.Join(got, ",") != want {
+		t.Errorf("pages = %q, want %q", got, want)
+	}
+}
+
+func TestGoBackendWorktreeStatus(t *testing.T) {
+	defer func(old GitBackend) { backend = old }(backend)
+	backend = goBackend{}
+	it := newIntegration(t)
+	defer os.RemoveAll(it.tmp)
+	defer it.start()()
+
+	_, url := it.bareRepo("demo")
+	clone := filepath.Join(it.root, "example.com", "alice", "demo")
+	it.git(it.tmp, "clone", "-q", url, clone)
+	wt := worktreePath(clone, "review")
+	it.git(clone, "worktree", "add", "-q", "-b", "review", wt)
+
+	it.write(filepath.Join(wt, "README.md"), "review\n")
+	it.git(wt, "commit", "-q", "-am", "Review")
+	it.write(filepath.Join(clone, "README.md"), "main\n")
+	it.git(clone, "commit", "-q", "-am", "Main")
+	cmd := exec.Command("git", "merge", "main")
+	cmd.Dir = wt
+	cmd.Run() // conflicts
+
+	var st statusResult
+	if code := it.post("/status", map[string]interface{}{"Worktree": "review"}, &st); code != http.StatusOK {
+		t.Fatalf("/status: status %d", code)
+	}
+	if st.Branch != "review" || st.Operation != opMerge {
+		t.Errorf("/status = %+v, want a merge in progress on review", st)
+	}
+}
+
+func TestSignedTagMessage(t *testing.T) {
+	it := newIntegration(t)
+	defer os.RemoveAll(it.tmp)
+	defer it.start()()
+
+	_, url := it.bareRepo("demo")
+	clone := filepath.Join(it.root, "example.com", "alice", "demo")
+	it.git(it.tmp, "clone", "-q", url, clone)
+
+	// A tag object as `git tag -s` writes it, without needing gpg.
+	obj := "object " + it.git(clone, "rev-parse", "HEAD") + "\ntype commit\ntag v1\ntagger Alice <alice@example.com> 1700000000 +0000\n\n" +
+		"Release 1\n\nFirst release.\n-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----\n"
+	cmd := exec.Command("git", "mktag")
+	cmd.Dir = clone
+	cmd.Stdin = strings.NewReader(obj)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.git(clone, "update-ref", "refs/tags/v1", strings.TrimSpace(string(out)))
+
+	var tags []tagInfo
+	if code := it.post("/tags", nil, &tags); code != http.StatusOK || len(tags) != 1 {
+		t.Fatalf("/tags: status %d: %+v", code, tags)
+	}
+	if want := "Release 1\n\nFirst release."; tags[0].Message != want {
+		t.Errorf("Message = %q, want %q", tags[0].Message, want)
+	}
+}
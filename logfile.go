@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFileName is the live log; rotated copies get a timestamp suffix.
+const logFileName = "gitify.log"
+
+// rotateRetry is how long logging carries on in the old file after a
+// rotation failed before it is tried again.
+const rotateRetry = time.Minute
+
+// renameFile moves the live log aside; tests replace it to make rotation fail.
+var renameFile = os.Rename
+
+// defaultLogDir is where the log goes when -log-dir is not given: the
+// platform's per-user location for application logs.
+func defaultLogDir() string {
+	switch runtime.GOOS {
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, "Library", "Logs", "Gitify")
+		}
+	case "windows":
+		// UserCacheDir is %LocalAppData% on Windows.
+		if dir, err := os.UserCacheDir(); err == nil {
+			return filepath.Join(dir, "Gitify", "logs")
+		}
+	default:
+		if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+			return filepath.Join(dir, "gitify")
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".local", "state", "gitify")
+		}
+	}
+	return filepath.Join(os.TempDir(), "gitify")
+}
+
+// rotatingFile is an append-only log file that is renamed aside once it
+// grows past maxSize bytes or was opened more than maxAge ago. At most
+// maxBackups rotated files are kept.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	f          *os.File
+	size       int64
+	opened     time.Time
+	retryAt    time.Time // no rotation before this, after one failed
+}
+
+func openRotatingFile(dir string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{path: filepath.Join(dir, logFileName), maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, info.Size(), rf.started(info)
+	return nil
+}
+
+// started returns when the live log was begun. It is kept in a file beside
+// the log since the modification time moves with every write, and so would
+// never reach maxAge on a server that logs at least once in that time.
+func (rf *rotatingFile) started(info os.FileInfo) time.Time {
+	stamp := rf.path + ".created"
+	if info.Size() > 0 {
+		if b, err := ioutil.ReadFile(stamp); err == nil {
+			if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b))); err == nil {
+				return t
+			}
+		}
+	}
+	t := time.Now()
+	if info.Size() > 0 {
+		// A log written before the stamp was; its last write is the best
+		// guess there is.
+		t = info.ModTime()
+	}
+	ioutil.WriteFile(stamp, []byte(t.Format(time.RFC3339Nano)+"\n"), 0644)
+	return t
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	due := rf.size+int64(len(p)) > rf.maxSize || time.Since(rf.opened) > rf.maxAge
+	if rf.size > 0 && due && time.Now().After(rf.retryAt) {
+		// Keep logging to the old file if it cannot be rotated, rather
+		// than trying again on every write.
+		if rf.rotate() != nil {
+			rf.retryAt = time.Now().Add(rotateRetry)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	stamp := time.Now().Format("20060102-150405.000")
+	rotated := strings.TrimSuffix(rf.path, ".log") + "-" + stamp + ".log"
+	renameErr := renameFile(rf.path, rotated)
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	old, _ := filepath.Glob(strings.TrimSuffix(rf.path, ".log") + "-*.log")
+	sort.Strings(old)
+	for len(old) > rf.maxBackups {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
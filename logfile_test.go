@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatesByAgeAcrossRestarts(t *testing.T) {
+	dir := tempRoot(t)
+	defer os.RemoveAll(dir)
+
+	rf, err := openRotatingFile(dir, 1<<20, time.Hour, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("first\n"))
+	rf.Close()
+
+	// The log was started two hours ago but written to just now.
+	started := time.Now().Add(-2 * time.Hour)
+	ioutil.WriteFile(rf.path+".created", []byte(started.Format(time.RFC3339Nano)+"\n"), 0644)
+	os.Chtimes(rf.path, time.Now(), time.Now())
+
+	rf, err = openRotatingFile(dir, 1<<20, time.Hour, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("second\n"))
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "gitify-*.log"))
+	if len(rotated) != 1 {
+		t.Fatalf("rotated logs = %q, want the old log moved aside", rotated)
+	}
+	if b, _ := ioutil.ReadFile(rf.path); string(b) != "second\n" {
+		t.Errorf("live log = %q, want only the new line", b)
+	}
+	if time.Since(rf.opened) > time.Minute {
+		t.Errorf("the new log is dated %s", rf.opened)
+	}
+}
+
+func TestFailedRotationIsNotRetriedOnEveryWrite(t *testing.T) {
+	dir := tempRoot(t)
+	defer os.RemoveAll(dir)
+	defer func(rename func(string, string) error) { renameFile = rename }(renameFile)
+	renames := 0
+	renameFile = func(string, string) error {
+		renames++
+		return errors.New("file in use")
+	}
+
+	rf, err := openRotatingFile(dir, 1<<20, time.Hour, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("first\n"))
+	rf.opened = time.Now().Add(-2 * time.Hour)
+
+	for i := 0; i < 3; i++ {
+		rf.Write([]byte("more\n"))
+	}
+	if renames != 1 {
+		t.Errorf("tried to rotate %d times, want once", renames)
+	}
+	if b, _ := ioutil.ReadFile(rf.path); string(b) != "first\nmore\nmore\nmore\n" {
+		t.Errorf("live log = %q, want every line kept", b)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return levelNames[l]
+}
+
+func parseLevel(name string) (logLevel, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q; use debug, info, warn or error", name)
+}
+
+// Log formats accepted by -log-format.
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// recentEntries is how many entries /logs can return.
+const recentEntries = 1000
+
+type logEntry struct {
+	Time   time.Time              `json:"Time"`
+	Level  string                 `json:"Level"`
+	Msg    string                 `json:"Msg"`
+	Fields map[string]interface{} `json:"Fields,omitempty"`
+}
+
+// leveledLogger writes entries with key/value fields as text or JSON lines
+// and keeps the most recent ones in memory for /logs.
+type leveledLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  logLevel
+	format string
+	path   string // the log file, if out writes to one
+	recent []logEntry
+	next   int
+}
+
+// logger is shared by every handler. server() points it at the log file;
+// until then it writes text to stdout.
+var logger = newLeveledLogger(os.Stdout, levelInfo, logText)
+
+func newLeveledLogger(out io.Writer, level logLevel, format string) *leveledLogger {
+	return &leveledLogger{out: out, level: level, format: format}
+}
+
+func (l *leveledLogger) Debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l *leveledLogger) Info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l *leveledLogger) Warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l *leveledLogger) Error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+// Fatal logs at error level and exits.
+func (l *leveledLogger) Fatal(msg string, kv ...interface{}) {
+	l.log(levelError, msg, kv)
+	os.Exit(1)
+}
+
+func (l *leveledLogger) log(level logLevel, msg string, kv []interface{}) {
+	if level < l.level {
+		return
+	}
+	e := logEntry{Time: time.Now(), Level: level.String(), Msg: redact(msg)}
+	if len(kv) > 0 {
+		e.Fields = make(map[string]interface{}, (len(kv)+1)/2)
+	}
+	var line bytes.Buffer
+	if l.format == logJSON {
+		line.WriteString(`{"Time":`)
+		writeJSONValue(&line, e.Time)
+		line.WriteString(`,"Level":`)
+		writeJSONValue(&line, e.Level)
+		line.WriteString(`,"Msg":`)
+		writeJSONValue(&line, e.Msg)
+	} else {
+		fmt.Fprintf(&line, "%s %-5s %s", e.Time.Format(time.RFC3339), strings.ToUpper(e.Level), e.Msg)
+	}
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		switch v := value.(type) {
+		case error:
+			value = redact(v.Error())
+		case string:
+			value = redact(v)
+		case []string:
+			masked := make([]string, len(v))
+			for i := range v {
+				masked[i] = redact(v[i])
+			}
+			value = masked
+		}
+		e.Fields[key] = value
+		if l.format == logJSON {
+			line.WriteByte(',')
+			writeJSONValue(&line, key)
+			line.WriteByte(':')
+			writeJSONValue(&line, value)
+		} else {
+			line.WriteString(" " + key + "=" + textValue(value))
+		}
+	}
+	if l.format == logJSON {
+		line.WriteByte('}')
+	}
+	line.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line.Bytes())
+	if len(l.recent) < recentEntries {
+		l.recent = append(l.recent, e)
+	} else {
+		l.recent[l.next] = e
+	}
+	l.next = (l.next + 1) % recentEntries
+}
+
+func writeJSONValue(b *bytes.Buffer, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		js, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(js)
+}
+
+// textValue quotes values that would otherwise be ambiguous in a text line.
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// writeRaw writes an already formatted line, such as a CLF access entry,
+// with credentials masked.
+func (l *leveledLogger) writeRaw(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write([]byte(redact(string(line))))
+}
+
+// tail returns up to n of the most recent entries at or above level,
+// oldest first.
+func (l *leveledLogger) tail(n int, level logLevel) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := []logEntry{}
+	for i := 0; i < len(l.recent); i++ {
+		e := l.recent[(l.next+i)%len(l.recent)]
+		if lv, _ := parseLevel(e.Level); lv >= level {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries
+}
+
+// logWriter adapts the leveled logger to the standard library's
+// *log.Logger, for http.Server.ErrorLog and stray log.Print calls.
+type logWriter struct {
+	l     *leveledLogger
+	level logLevel
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.l.log(w.level, strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+func (l *leveledLogger) std(level logLevel) *log.Logger {
+	return log.New(logWriter{l, level}, "", 0)
+}
+
+// tailLog returns the most recent log entries, oldest first. The lines
+// query parameter limits how many (default 100) and level filters out
+// anything less severe.
+func tailLog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		n := 100
+		if v := r.URL.Query().Get("lines"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil || n < 1 {
+				http.Error(w, "lines must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		level := levelDebug
+		if v := r.URL.Query().Get("level"); v != "" {
+			var err error
+			if level, err = parseLevel(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Path    string     `json:"Path"`
+			Entries []logEntry `json:"Entries"`
+		}{logger.path, logger.tail(n, level)})
+	})
+}
+
+// openLog opens the log file with the application the OS associates with
+// it, standing in for a tray menu item.
+func openLog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		if r.Method != "POST" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if logger.path == "" {
+			http.Error(w, "not logging to a file", http.StatusNotFound)
+			return
+		}
+		cmd := openCommand(logger.path)
+		hideWindow(cmd)
+		if err := runnerFrom(r.Context()).Run(r.Context(), cmd); err != nil {
+			logger.Error("could not open the log", "path", logger.path, "err", err)
+			http.Error(w, "could not open the log: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"runtime"
 
 	"github.com/cratonica/trayhost"
-
 )
 
 // Refer to documentation at http://github.com/cratonica/trayhost for generating this
 // var iconData []byte
 
 func main() {
+	// git and ssh start this executable again when they need credentials
+	if isAskpassHelper() {
+		os.Exit(askpassMain(os.Args[1:]))
+	}
+
 	// EnterLoop must be called on the OS's main thread
 	runtime.LockOSThread()
 
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are kept in memory and served in the Prometheus text format at
+// /metrics. Only the two kinds this server needs are implemented.
+
+// durationBuckets are upper bounds in seconds, wide enough for a large
+// clone.
+var durationBuckets = []float64{0.005, 0.025, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// counterVec is a counter per combination of label values.
+type counterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(values, "\xff")]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelSet(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a histogram per combination of label values.
+type histogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(values, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelSet(h.labels, key, formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelSet(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelSet(h.labels, key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelSet(h.labels, key, ""), s.count)
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(value))
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelSet renders {name="value",...} for the joined label values in key,
+// adding le when it is not empty.
+func labelSet(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+"="+strconv.Quote(v))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	httpRequests = newCounterVec("gitify_http_requests_total",
+		"HTTP requests by route, method and status.", "route", "method", "status")
+	httpDuration = newHistogramVec("gitify_http_request_duration_seconds",
+		"How long HTTP requests took by route and method.", durationBuckets, "route", "method")
+	httpInFlight int32
+
+	gitCommands = newCounterVec("gitify_git_commands_total",
+		"git commands by subcommand and exit code; timeout and cancelled stand in for the code when killed.", "subcommand", "exit_code")
+	gitDuration = newHistogramVec("gitify_git_command_duration_seconds",
+		"How long git commands ran by subcommand, not counting time waiting for a job slot.", durationBuckets, "subcommand")
+)
+
+// observeGit records a finished git command, run by either backend.
+func observeGit(s gitStep, d time.Duration) {
+	sub := subcommand(s.Args)
+	code := strconv.Itoa(s.ExitCode)
+	switch {
+	case s.TimedOut:
+		code = "timeout"
+	case s.Cancelled:
+		code = "cancelled"
+	}
+	gitCommands.inc(sub, code)
+	gitDuration.observe(d.Seconds(), sub)
+}
+
+// instrument counts and times the requests router serves, labelled by the
+// pattern they matched so that arbitrary paths do not each get a series.
+func instrument(router *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := router.Handler(r)
+		if route == "" {
+			route = "none"
+		}
+		atomic.AddInt32(&httpInFlight, 1)
+		defer atomic.AddInt32(&httpInFlight, -1)
+
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		router.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		httpRequests.inc(route, r.Method, strconv.Itoa(rec.status))
+		httpDuration.observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// metrics serves every metric in the Prometheus text exposition format.
+func metrics() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b bytes.Buffer
+		httpRequests.write(&b)
+		httpDuration.write(&b)
+		writeGauge(&b, "gitify_http_requests_in_flight", "HTTP requests being served.", float64(atomic.LoadInt32(&httpInFlight)))
+		gitCommands.write(&b)
+		gitDuration.write(&b)
+		used, capacity := gitJobs.usage()
+		writeGauge(&b, "gitify_git_jobs_running", "git operations holding a job slot.", float64(used))
+		writeGauge(&b, "gitify_git_jobs_queued", "git operations waiting for a job slot.", float64(gitJobs.queued()))
+		writeGauge(&b, "gitify_git_jobs_capacity", "How many git operations may run at once.", float64(capacity))
+		writeGauge(&b, "gitify_healthy", "1 while the server accepts requests, 0 while it shuts down.", float64(atomic.LoadInt32(&healthy)))
+		fmt.Fprintf(&b, "# HELP gitify_build_info The running version.\n# TYPE gitify_build_info gauge\ngitify_build_info{version=%q} 1\n", version)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
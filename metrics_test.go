@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+
+	runner := newFakeRunner().on("git commit", fakeReply{Stdout: "nothing to commit", ExitCode: 1})
+	handler := withRunner(runner)(instrument(newRouter()))
+	for _, path := range []string{"/gitPush", "/no/such/path"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(repoJSON(root, `"GitMsg":"fix typo"`)))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`gitify_http_requests_total{route="/gitPush",method="POST",status="500"} `,
+		`gitify_http_requests_total{route="/",method="POST",status="404"} `,
+		`gitify_http_request_duration_seconds_bucket{route="/gitPush",method="POST",le="+Inf"} `,
+		`gitify_git_commands_total{subcommand="add",exit_code="0"} `,
+		`gitify_git_commands_total{subcommand="commit",exit_code="1"} `,
+		`gitify_git_command_duration_seconds_count{subcommand="commit"} `,
+		"gitify_git_jobs_capacity ",
+		"gitify_healthy ",
+		"# TYPE gitify_git_command_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics is missing %q:\n%s", want, body)
+		}
+	}
+}
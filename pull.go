@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// Pull strategies accepted in pullRequest.Strategy. Passing one explicitly
+// keeps the outcome independent of the user's pull.rebase setting.
+const (
+	pullFastForward = "ff-only"
+	pullRebase      = "rebase"
+	pullMerge       = "merge"
+)
+
+type pullRequest struct {
+	gitData
+	Remote            string `json:"Remote"`
+	Branch            string `json:"Branch"`
+	Strategy          string `json:"Strategy"`
+	Autostash         bool   `json:"Autostash"`
+	RecurseSubmodules bool   `json:"RecurseSubmodules"`
+}
+
+// pullResult reports what a pull changed in addition to the steps it ran.
+type pullResult struct {
+	*gitResult
+	OldHead         string   `json:"OldHead"`
+	NewHead         string   `json:"NewHead"`
+	CommitsPulled   int      `json:"CommitsPulled"`
+	FilesChanged    []string `json:"FilesChanged"`
+	Conflicts       bool     `json:"Conflicts"`
+	ConflictedFiles []string `json:"ConflictedFiles"`
+}
+
+// pullArgs builds the git pull command line for msg. It reports false when
+// the requested strategy is unknown.
+func pullArgs(msg pullRequest) ([]string, bool) {
+	args := []string{"pull"}
+	switch msg.Strategy {
+	case "", pullFastForward:
+		args = append(args, "--ff-only")
+	case pullRebase:
+		args = append(args, "--rebase")
+	case pullMerge:
+		args = append(args, "--no-rebase")
+	default:
+		return nil, false
+	}
+	if msg.Autostash {
+		args = append(args, "--autostash")
+	}
+	if msg.RecurseSubmodules {
+		args = append(args, "--recurse-submodules")
+	}
+	if msg.Remote != "" {
+		args = append(args, msg.Remote)
+		if msg.Branch != "" {
+			args = append(args, msg.Branch)
+		}
+	}
+	return args, true
+}
+
+// conflictedFiles lists the paths with unresolved merge conflicts.
+func conflictedFiles(ctx context.Context, dir string) []string {
+	return runGit(ctx, dir, "conflicts", "diff", "--name-only", "--diff-filter=U").lines()
+}
+
+func gitPull() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg pullRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if rejectOption(w, "Remote", msg.Remote) || rejectOption(w, "Branch", msg.Branch) {
+			return
+		}
+		if _, ok := pullArgs(msg); !ok {
+			http.Error(w, "unknown pull strategy "+strconv.Quote(msg.Strategy), http.StatusBadRequest)
+			return
+		}
+
+		res := backend.Pull(ctx, msg.checkoutPath(), msg)
+		if !res.Success {
+			logger.Warn("git pull failed", "reason", res.Reason)
+		}
+
+		status := resultStatus(res.gitResult)
+		if res.Conflicts {
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, res)
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Hosting providers whose pull/merge request refs we know how to fetch.
+const (
+	providerGitHub = "github"
+	providerGitLab = "gitlab"
+)
+
+type checkoutPRRequest struct {
+	gitData
+	PullURL  string `json:"PullURL"`
+	Number   int    `json:"Number"`
+	Provider string `json:"Provider"`
+	Open     bool   `json:"Open"`
+}
+
+type checkoutPRResult struct {
+	*gitResult
+	Branch string `json:"Branch"`
+	Path   string `json:"Path"`
+}
+
+// parsePullURL fills in the repository identity, provider and number from
+// a GitHub pull request or GitLab merge request URL such as
+// https://github.com/owner/repo/pull/12 or
+// https://gitlab.com/group/sub/project/-/merge_requests/34, including the
+// tab URLs under them like .../pull/12/files or .../merge_requests/34/diffs.
+func parsePullURL(msg *checkoutPRRequest) error {
+	u, err := url.Parse(msg.PullURL)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	var repo []string
+	number := ""
+	for i := 0; i+1 < len(parts) && repo == nil; i++ {
+		switch {
+		case parts[i] == "-" && parts[i+1] == "merge_requests":
+			msg.Provider = providerGitLab
+			repo = parts[:i]
+			if i+2 < len(parts) {
+				number = parts[i+2]
+			}
+		case parts[i] == "pull" && i >= 2:
+			msg.Provider = providerGitHub
+			repo = parts[:i]
+			number = parts[i+1]
+		}
+	}
+	if repo == nil {
+		return fmt.Errorf("%q is not a pull or merge request URL", msg.PullURL)
+	}
+	if len(repo) < 2 {
+		return fmt.Errorf("%q does not name a repository", msg.PullURL)
+	}
+	if msg.Number, err = strconv.Atoi(number); err != nil {
+		return fmt.Errorf("%q does not include a request number", msg.PullURL)
+	}
+
+	msg.Domain = u.Host
+	msg.GitUserName = strings.Join(repo[:len(repo)-1], "/")
+	msg.ProjectName = repo[len(repo)-1]
+	if msg.RepoURL == "" {
+		msg.RepoURL = fmt.Sprintf("%s://%s/%s.git", u.Scheme, u.Host, strings.Join(repo, "/"))
+	}
+	return nil
+}
+
+// resolvePullRequest completes msg from its PullURL, or from Number and
+// the repository's Domain when no URL is given.
+func resolvePullRequest(msg *checkoutPRRequest) error {
+	if msg.PullURL != "" {
+		if err := parsePullURL(msg); err != nil {
+			return err
+		}
+	}
+	if msg.Number <= 0 {
+		return errors.New("PullURL or Number is required")
+	}
+	if msg.Provider == "" {
+		msg.Provider = providerGitHub
+		if strings.Contains(msg.Domain, "gitlab") {
+			msg.Provider = providerGitLab
+		}
+	}
+	return nil
+}
+
+// pullRefs returns the ref the provider publishes the request head under,
+// the remote-tracking ref we fetch it into, and the local review branch.
+func pullRefs(provider string, number int) (remoteRef, trackingRef, branch string) {
+	if provider == providerGitLab {
+		return fmt.Sprintf("refs/merge-requests/%d/head", number),
+			fmt.Sprintf("refs/remotes/origin/mr/%d", number),
+			fmt.Sprintf("mr/%d", number)
+	}
+	return fmt.Sprintf("refs/pull/%d/head", number),
+		fmt.Sprintf("refs/remotes/origin/pull/%d", number),
+		fmt.Sprintf("pr/%d", number)
+}
+
+// cloneStep clones msg.RepoURL into the directory repoPath expects.
+func cloneStep(ctx context.Context, msg gitData) gitStep {
+	repoBase := filepath.Join(msg.RootPath, msg.Domain, msg.GitUserName)
+	if err := os.MkdirAll(repoBase, os.ModePerm); err != nil {
+		return gitStep{Step: "clone", ExitCode: -1, Error: err.Error()}
+	}
+	return runGit(ctx, repoBase, "clone", "clone", msg.RepoURL, msg.ProjectName)
+}
+
+// checkoutPullRequest makes a pull/merge request available locally: it
+// clones the base repository when missing, fetches the request head and
+// switches to a review branch whose upstream is that head, so a later
+// pull picks up new pushes to the request.
+func checkoutPullRequest() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg checkoutPRRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if msg.Worktree != "" {
+			http.Error(w, "Worktree is not supported here; use /addWorktree to review in a worktree", http.StatusBadRequest)
+			return
+		}
+		if err := resolvePullRequest(&msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		repoPath := msg.repoPath()
+		remoteRef, trackingRef, branch := pullRefs(msg.Provider, msg.Number)
+		res := checkoutPRResult{gitResult: newGitResult(), Branch: branch, Path: repoPath}
+
+		if ok, _ := exists(repoPath); !ok {
+			logger.Info("cloning for review", "url", msg.RepoURL)
+			res.add(cloneStep(ctx, msg.gitData))
+		}
+		res.run(func() gitStep {
+			return runGit(ctx, repoPath, "fetch", "fetch", "origin", "+"+remoteRef+":"+trackingRef)
+		})
+		if res.Success {
+			if gitOutput(ctx, repoPath, "rev-parse", "--verify", "-q", "refs/heads/"+branch) != "" {
+				res.run(
+					func() gitStep { return runGit(ctx, repoPath, "switch", "switch", branch) },
+					func() gitStep { return runGit(ctx, repoPath, "update", "merge", "--ff-only", trackingRef) },
+				)
+			} else {
+				res.run(func() gitStep { return runGit(ctx, repoPath, "switch", "switch", "-c", branch, trackingRef) })
+			}
+		}
+		res.run(
+			func() gitStep { return runGit(ctx, repoPath, "track", "config", "branch."+branch+".remote", "origin") },
+			func() gitStep { return runGit(ctx, repoPath, "track", "config", "branch."+branch+".merge", remoteRef) },
+		)
+
+		if res.Success && msg.Open {
+			if _, err := openEditor(ctx, repoPath); err != nil {
+				res.add(gitStep{Step: "open", ExitCode: -1, Error: err.Error()})
+			}
+		}
+		if !res.Success {
+			logger.Warn("pull request checkout failed", "branch", branch, "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeJSON(w, resultStatus(res.gitResult), res)
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParsePullURL(t *testing.T) {
+	tests := []struct {
+		url                     string
+		provider, user, project string
+		number                  int
+	}{
+		{"https://github.com/alice/demo/pull/12", providerGitHub, "alice", "demo", 12},
+		{"https://github.com/alice/demo/pull/12/files", providerGitHub, "alice", "demo", 12},
+		{"https://github.com/alice/demo/pull/12/commits", providerGitHub, "alice", "demo", 12},
+		{"https://github.com/alice/pull/pull/7/", providerGitHub, "alice", "pull", 7},
+		{"https://gitlab.com/group/sub/project/-/merge_requests/34", providerGitLab, "group/sub", "project", 34},
+		{"https://gitlab.com/group/sub/project/-/merge_requests/34/diffs", providerGitLab, "group/sub", "project", 34},
+	}
+	for _, tt := range tests {
+		msg := checkoutPRRequest{PullURL: tt.url}
+		if err := parsePullURL(&msg); err != nil {
+			t.Errorf("parsePullURL(%q): %v", tt.url, err)
+			continue
+		}
+		if msg.Provider != tt.provider || msg.GitUserName != tt.user || msg.ProjectName != tt.project || msg.Number != tt.number {
+			t.Errorf("parsePullURL(%q) = %s %s/%s #%d, want %s %s/%s #%d", tt.url,
+				msg.Provider, msg.GitUserName, msg.ProjectName, msg.Number, tt.provider, tt.user, tt.project, tt.number)
+		}
+	}
+
+	for _, url := range []string{
+		"https://github.com/alice/demo",
+		"https://github.com/alice/demo/pull/files",
+		"https://github.com/pull/12",
+		"https://gitlab.com/group/project/-/merge_requests",
+	} {
+		msg := checkoutPRRequest{PullURL: url}
+		if err := parsePullURL(&msg); err == nil {
+			t.Errorf("parsePullURL(%q) accepted it", url)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type remoteInfo struct {
+	Name     string `json:"Name"`
+	FetchURL string `json:"FetchURL"`
+	PushURL  string `json:"PushURL"`
+}
+
+type remoteRequest struct {
+	gitData
+	Name    string `json:"Name"`
+	NewName string `json:"NewName"`
+	URL     string `json:"URL"`
+	Push    bool   `json:"Push"`
+}
+
+type syncForkRequest struct {
+	gitData
+	Upstream string `json:"Upstream"`
+	Origin   string `json:"Origin"`
+	Branch   string `json:"Branch"`
+	Rebase   bool   `json:"Rebase"`
+}
+
+type syncForkResult struct {
+	*gitResult
+	Branch string `json:"Branch"`
+}
+
+// listRemotes parses `git remote -v`, which prints a fetch and a push line
+// per remote.
+func listRemotes(ctx context.Context, dir string) ([]remoteInfo, gitStep) {
+	remotes := []remoteInfo{}
+	s := runGit(ctx, dir, "remotes", "remote", "-v")
+	if !s.ok() {
+		return remotes, s
+	}
+	index := map[string]int{}
+	for _, line := range s.lines() {
+		// <name> TAB <url> SP (fetch|push)
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			continue
+		}
+		i, ok := index[f[0]]
+		if !ok {
+			i = len(remotes)
+			index[f[0]] = i
+			remotes = append(remotes, remoteInfo{Name: f[0]})
+		}
+		if f[2] == "(push)" {
+			remotes[i].PushURL = f[1]
+		} else {
+			remotes[i].FetchURL = f[1]
+		}
+	}
+	return remotes, s
+}
+
+func remotes() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		list, s := listRemotes(ctx, msg.checkoutPath())
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+}
+
+// remoteHandler decodes a remoteRequest, requires Name and the listed
+// fields, and runs the single git command built by args.
+func remoteHandler(step string, required []string, args func(msg remoteRequest) []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg remoteRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		values := map[string]string{"Name": msg.Name, "NewName": msg.NewName, "URL": msg.URL}
+		for _, field := range append([]string{"Name"}, required...) {
+			if values[field] == "" {
+				http.Error(w, field+" is required", http.StatusBadRequest)
+				return
+			}
+			if strings.HasPrefix(values[field], "-") {
+				http.Error(w, field+" must not start with '-'", http.StatusBadRequest)
+				return
+			}
+		}
+		runSingle(w, runGit(ctx, msg.checkoutPath(), step, args(msg)...))
+	})
+}
+
+func addRemote() http.Handler {
+	return remoteHandler("add", []string{"URL"}, func(msg remoteRequest) []string {
+		return []string{"remote", "add", msg.Name, msg.URL}
+	})
+}
+
+func renameRemote() http.Handler {
+	return remoteHandler("rename", []string{"NewName"}, func(msg remoteRequest) []string {
+		return []string{"remote", "rename", msg.Name, msg.NewName}
+	})
+}
+
+func removeRemote() http.Handler {
+	return remoteHandler("remove", nil, func(msg remoteRequest) []string {
+		return []string{"remote", "remove", msg.Name}
+	})
+}
+
+// setRemoteURL changes a remote's URL, or only its push URL when Push is
+// set.
+func setRemoteURL() http.Handler {
+	return remoteHandler("set-url", []string{"URL"}, func(msg remoteRequest) []string {
+		if msg.Push {
+			return []string{"remote", "set-url", "--push", msg.Name, msg.URL}
+		}
+		return []string{"remote", "set-url", msg.Name, msg.URL}
+	})
+}
+
+// defaultBranch returns the branch remote's HEAD points at, asking the
+// remote when the local copy of its HEAD is missing.
+func defaultBranch(ctx context.Context, dir, remote string) string {
+	head := gitOutput(ctx, dir, "symbolic-ref", "--short", "refs/remotes/"+remote+"/HEAD")
+	if head == "" {
+		runGit(ctx, dir, "set-head", "remote", "set-head", remote, "--auto")
+		head = gitOutput(ctx, dir, "symbolic-ref", "--short", "refs/remotes/"+remote+"/HEAD")
+	}
+	return strings.TrimPrefix(head, remote+"/")
+}
+
+// syncFork brings the fork's default branch up to date with upstream:
+// it fetches Upstream, fast-forwards (or rebases) the local branch onto
+// it and pushes the result to Origin. Rebasing is only done on the branch
+// that is checked out, so the user's working tree is never switched.
+func syncFork() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg syncForkRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if rejectOption(w, "Upstream", msg.Upstream) || rejectOption(w, "Origin", msg.Origin) || rejectOption(w, "Branch", msg.Branch) {
+			return
+		}
+		if msg.Upstream == "" {
+			msg.Upstream = "upstream"
+		}
+		if msg.Origin == "" {
+			msg.Origin = "origin"
+		}
+		dir := msg.checkoutPath()
+
+		res := syncForkResult{gitResult: newGitResult()}
+		if !res.add(runGit(ctx, dir, "fetch", "fetch", "--prune", "--", msg.Upstream)) {
+			writeJSON(w, resultStatus(res.gitResult), res)
+			return
+		}
+		if msg.Branch == "" {
+			msg.Branch = defaultBranch(ctx, dir, msg.Upstream)
+			if strings.HasPrefix(msg.Branch, "-") {
+				http.Error(w, "the default branch of "+msg.Upstream+" starts with '-'", http.StatusConflict)
+				return
+			}
+		}
+		if msg.Branch == "" {
+			http.Error(w, "could not determine the default branch of "+msg.Upstream, http.StatusConflict)
+			return
+		}
+		res.Branch = msg.Branch
+		upstreamRef := msg.Upstream + "/" + msg.Branch
+		current := gitOutput(ctx, dir, "symbolic-ref", "--short", "-q", "HEAD") == msg.Branch
+
+		pushArgs := []string{"push", "--", msg.Origin, msg.Branch}
+		switch {
+		case msg.Rebase && !current:
+			http.Error(w, "Rebase requires "+msg.Branch+" to be checked out", http.StatusConflict)
+			return
+		case msg.Rebase:
+			res.add(runGit(ctx, dir, "rebase", "rebase", upstreamRef))
+			pushArgs = []string{"push", "--force-with-lease", "--", msg.Origin, msg.Branch}
+		case current:
+			res.add(runGit(ctx, dir, "update", "merge", "--ff-only", upstreamRef))
+		default:
+			// Fast-forward a branch that is not checked out.
+			res.add(runGit(ctx, dir, "update", "fetch", ".", upstreamRef+":"+msg.Branch))
+		}
+		res.run(func() gitStep { return runGit(ctx, dir, "push", pushArgs...) })
+
+		if !res.Success {
+			logger.Warn("syncFork failed", "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeJSON(w, resultStatus(res.gitResult), res)
+	})
+}
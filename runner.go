@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+)
+
+// CommandRunner runs the processes the handlers start: git and the editor.
+// The server uses execRunner; tests swap in a fake so handlers can be
+// exercised without either installed.
+type CommandRunner interface {
+	// Run runs cmd to completion, killing it and everything it started if
+	// ctx is done first. A non-zero exit is reported as an error with an
+	// ExitCode method, like *exec.ExitError.
+	Run(ctx context.Context, cmd *exec.Cmd) error
+}
+
+// execRunner runs commands for real through os/exec.
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killTree(cmd)
+		case <-exited:
+		}
+	}()
+	return cmd.Wait()
+}
+
+// withRunner makes handlers start their processes through runner.
+func withRunner(runner CommandRunner) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), runnerKey, runner)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// runnerFrom returns the runner installed by withRunner, or execRunner
+// when there is none.
+func runnerFrom(ctx context.Context) CommandRunner {
+	if runner, ok := ctx.Value(runnerKey).(CommandRunner); ok {
+		return runner
+	}
+	return execRunner{}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCall is one command a fakeRunner was asked to run.
+type fakeCall struct {
+	Dir  string
+	Args []string
+}
+
+func (c fakeCall) String() string {
+	return strings.Join(c.Args, " ")
+}
+
+// fakeReply is scripted output for a command.
+type fakeReply struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+type fakeExitError int
+
+func (e fakeExitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e fakeExitError) ExitCode() int { return int(e) }
+
+// fakeRunner records every command instead of running it. Commands whose
+// line starts with a scripted prefix get the scripted replies in order, the
+// last one repeating; anything else succeeds with no output.
+type fakeRunner struct {
+	mu       sync.Mutex
+	calls    []fakeCall
+	prefixes []string
+	replies  map[string][]fakeReply
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{replies: map[string][]fakeReply{}}
+}
+
+// on scripts the replies for commands starting with prefix, such as
+// "git push".
+func (f *fakeRunner) on(prefix string, replies ...fakeReply) *fakeRunner {
+	if _, ok := f.replies[prefix]; !ok {
+		f.prefixes = append(f.prefixes, prefix)
+	}
+	f.replies[prefix] = replies
+	return f
+}
+
+func (f *fakeRunner) Run(ctx context.Context, cmd *exec.Cmd) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	call := fakeCall{Dir: cmd.Dir, Args: cmd.Args}
+	f.calls = append(f.calls, call)
+
+	var reply fakeReply
+	line := call.String()
+	for _, prefix := range f.prefixes {
+		if line != prefix && !strings.HasPrefix(line, prefix+" ") {
+			continue
+		}
+		replies := f.replies[prefix]
+		reply = replies[0]
+		if len(replies) > 1 {
+			f.replies[prefix] = replies[1:]
+		}
+		break
+	}
+	if cmd.Stdout != nil {
+		io.WriteString(cmd.Stdout, reply.Stdout)
+	}
+	if cmd.Stderr != nil {
+		io.WriteString(cmd.Stderr, reply.Stderr)
+	}
+	if reply.ExitCode != 0 {
+		return fakeExitError(reply.ExitCode)
+	}
+	return nil
+}
+
+// commands returns the command lines run so far.
+func (f *fakeRunner) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := make([]string, len(f.calls))
+	for i, c := range f.calls {
+		lines[i] = c.String()
+	}
+	return lines
+}
+
+// ran reports whether a command starting with prefix was run in dir.
+func (f *fakeRunner) ran(dir, prefix string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if line := c.String(); c.Dir == dir && (line == prefix || strings.HasPrefix(line, prefix+" ")) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExecRunnerCancel(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not installed")
+	}
+	// The editor and the log opener run outside a process group of their
+	// own, so cancelling must not rely on killing one.
+	for _, group := range []bool{true, false} {
+		cmd := exec.Command("sleep", "30")
+		if group {
+			newProcessGroup(cmd)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := execRunner{}.Run(ctx, cmd)
+		cancel()
+		if err == nil || time.Since(start) > 10*time.Second {
+			t.Errorf("group %v: Run returned %v after %s, want it killed", group, err, time.Since(start))
+		}
+	}
+}
+
+// finishThenCancel stands in for a request that ends just after git exits.
+type finishThenCancel struct {
+	cancel context.CancelFunc
+}
+
+func (r finishThenCancel) Run(ctx context.Context, cmd *exec.Cmd) error {
+	r.cancel()
+	return nil
+}
+
+func TestRunGitFinishedBeforeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = context.WithValue(ctx, runnerKey, finishThenCancel{cancel})
+	if s := runGit(ctx, "", "status", "status"); !s.ok() || s.Cancelled {
+		t.Errorf("step = %+v, want the successful result", s)
+	}
+}
@@ -3,20 +3,22 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync/atomic"
 	"time"
-
 )
 
 type key int
 
 const (
 	requestIDKey key = 0
+	runnerKey    key = 1
+	spanKey      key = 2
 )
 
 var (
@@ -32,43 +34,106 @@ func server() {
 	// Be sure to call this to link the tray icon to the target url
 
 	flag.StringVar(&listenAddr, "listen-addr", ":5000", "server listen address")
+	flag.DurationVar(&askpassTimeout, "askpass-timeout", 2*time.Minute, "how long git waits for a credentials prompt to be answered")
+	flag.Var(&askpassOrigins, "askpass-origin", "extension origin allowed to answer credential prompts, e.g. chrome-extension://<id>; repeat for several (default any extension)")
+	flag.Var(gitTimeouts, "git-timeout", "per-subcommand git time limits, e.g. \"push=5m,default=1m\"")
+	maxJobs := flag.Int("max-git-jobs", 8, "how many git operations may run at once")
+	flag.Var(&workspaceRoots, "root", "workspace root the extension clones into; repeat for several")
+	flag.StringVar(&accessLogFormat, "access-log-format", accessText, "access log format: text, clf (Common Log Format) or json")
+	flag.Var(accessSampling, "access-log-sample", "fraction of requests logged per path, e.g. \"/askpass/prompts=0.1,default=1\"; failures are always logged")
+	backendName := flag.String("git-backend", backendAuto, "git implementation: auto, exec (the git binary) or go (built in)")
+	logDir := flag.String("log-dir", defaultLogDir(), "directory for gitify.log and its rotated copies")
+	logLevelName := flag.String("log-level", "info", "lowest level logged: debug, info, warn or error")
+	logFormat := flag.String("log-format", logText, "log format: text or json")
+	logMaxSize := flag.Int64("log-max-size", 10, "megabytes after which the log is rotated")
+	logMaxAge := flag.Duration("log-max-age", 7*24*time.Hour, "age after which the log is rotated")
+	logMaxBackups := flag.Int("log-max-backups", 5, "how many rotated logs to keep")
+	traceExporter := flag.String("trace-exporter", traceNone, "where spans go: none, file or otlp")
+	traceFilePath := flag.String("trace-file", "", "span file for -trace-exporter file (default traces.jsonl in -log-dir)")
+	traceEndpoint := flag.String("trace-endpoint", defaultOTLPEndpoint(), "OTLP/HTTP traces URL for -trace-exporter otlp")
+	auditPath := flag.String("audit-log", "", "append-only audit trail of changes to repositories (default audit.jsonl in -log-dir)")
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
+	level, err := parseLevel(*logLevelName)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	if *logFormat != logText && *logFormat != logJSON {
+		logger.Fatal("unknown log format; use text or json", "format", *logFormat)
+	}
+	var out io.Writer = os.Stdout
+	logFile, err := openRotatingFile(*logDir, *logMaxSize<<20, *logMaxAge, *logMaxBackups)
+	if err != nil {
+		logger.Error("could not open the log file; logging to stdout only", "dir", *logDir, "err", err)
+	} else {
+		defer logFile.Close()
+		out = io.MultiWriter(logFile, os.Stdout)
+	}
+	logger = newLeveledLogger(out, level, *logFormat)
+	if logFile != nil {
+		logger.path = logFile.path
+	}
+	log.SetFlags(0)
+	log.SetOutput(logWriter{logger, levelInfo})
 
-	logger.Println("gitifyServer server")
+	if *auditPath == "" {
+		*auditPath = filepath.Join(*logDir, auditFileName)
+	}
+	if audit, err = openAuditTrail(*auditPath); err != nil {
+		logger.Error("could not open the audit trail; changes will not be audited", "path", *auditPath, "err", err)
+	} else {
+		defer audit.Close()
+	}
 
-	logger.Println("Server is starting...")
+	switch accessLogFormat {
+	case accessText, accessCLF, accessJSON:
+	default:
+		logger.Fatal("unknown access log format; use text, clf or json", "format", accessLogFormat)
+	}
 
-	router := http.NewServeMux()
-	router.Handle("/", index())
-	router.Handle("/repoExists", repoExists())
-	router.Handle("/gitClone", gitClone())
-	router.Handle("/openVSCode", openVsCode())
-	router.Handle("/gitPush", gitPush())
-	router.Handle("/gitPull", gitPull())
-	router.Handle("/healthz", healthz())
+	switch *traceExporter {
+	case traceNone:
+	case traceFile:
+		if *traceFilePath == "" {
+			*traceFilePath = filepath.Join(*logDir, traceFileName)
+		}
+		exporter, err := newFileExporter(*traceFilePath)
+		if err != nil {
+			logger.Fatal("could not open the trace file", "path", *traceFilePath, "err", err)
+		}
+		traces = newTracer(exporter)
+		logger.Info("writing spans to a file", "path", *traceFilePath)
+	case traceOTLP:
+		traces = newTracer(newOTLPExporter(*traceEndpoint))
+		logger.Info("exporting spans over OTLP", "endpoint", *traceEndpoint)
+	default:
+		logger.Fatal("unknown trace exporter; use none, file or otlp", "exporter", *traceExporter)
+	}
 
-	nextRequestID := func() string {
-		return fmt.Sprintf("%d", time.Now().UnixNano())
+	chosen, err := chooseBackend(context.Background(), *backendName)
+	if err != nil {
+		logger.Fatal(err.Error())
 	}
+	backend = chosen
+	gitJobs = newJobQueue(*maxJobs)
+	logger.Info("using git backend", "backend", backend.Name())
 
-	server := &http.Server{
-		Addr:         listenAddr,
-		Handler:      tracing(nextRequestID)(logging(logger)(router)),
-		ErrorLog:     logger,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  50 * time.Second,
+	if err := setupAskpass(listenAddr); err != nil {
+		logger.Warn("credential prompts are disabled", "err", err)
 	}
 
+	logger.Info("gitifyServer is starting", "version", version, "log", logger.path)
+
+	server := newServer(logger)
+	server.Addr = listenAddr
+
 	done := make(chan bool)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 
 	go func() {
 		<-quit
-		logger.Println("Server is shutting down...")
+		logger.Info("server is shutting down")
 		atomic.StoreInt32(&healthy, 0)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -76,18 +141,94 @@ func server() {
 
 		server.SetKeepAlivesEnabled(false)
 		if err := server.Shutdown(ctx); err != nil {
-			logger.Fatalf("Could not gracefully shutdown the server: %v\n", err)
+			logger.Fatal("could not gracefully shut down the server", "err", err)
+		}
+		if err := traces.shutdown(ctx); err != nil {
+			logger.Warn("could not export the remaining spans", "err", err)
 		}
 		close(done)
 	}()
 
-	logger.Println("Server is ready to handle requests at", listenAddr)
+	logger.Info("server is ready to handle requests", "addr", listenAddr)
 	atomic.StoreInt32(&healthy, 1)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Fatalf("Could not listen on %s: %v\n", listenAddr, err)
+		logger.Fatal("could not listen", "addr", listenAddr, "err", err)
 	}
 
 	<-done
-	logger.Println("Server stopped")
+	logger.Info("server stopped")
+}
+
+// newServer builds the HTTP server with every endpoint and middleware in
+// place, ready to be started on any address.
+func newServer(logger *leveledLogger) *http.Server {
+	return &http.Server{
+		Handler:     tracing(traces)(logging(logger)(redaction(withRunner(execRunner{})(auditing(instrument(newRouter())))))),
+		ErrorLog:    logger.std(levelError),
+		ReadTimeout: 5 * time.Second,
+		// Responses may take as long as the slowest git command is allowed
+		// to run, plus time for it to wait on a credentials prompt.
+		WriteTimeout: gitTimeouts.longest() + askpassTimeout + time.Minute,
+		IdleTimeout:  50 * time.Second,
+	}
+}
 
+// newRouter maps every endpoint to its handler.
+func newRouter() *http.ServeMux {
+	router := http.NewServeMux()
+	router.Handle("/", index())
+	router.Handle("/repoExists", repoExists())
+	router.Handle("/gitClone", gitClone())
+	router.Handle("/openVSCode", openVsCode())
+	router.Handle("/stage", stage())
+	router.Handle("/commit", commit())
+	router.Handle("/push", push())
+	router.Handle("/fetch", fetch())
+	router.Handle("/gitPush", gitPush())
+	router.Handle("/gitPull", gitPull())
+	router.Handle("/status", status())
+	router.Handle("/conflicts", conflicts())
+	router.Handle("/resolveConflict", resolveConflict())
+	router.Handle("/continueMerge", continueMerge())
+	router.Handle("/abortMerge", abortMerge())
+	router.Handle("/branches", branches())
+	router.Handle("/createBranch", createBranch())
+	router.Handle("/switchBranch", switchBranch())
+	router.Handle("/renameBranch", renameBranch())
+	router.Handle("/deleteBranch", deleteBranch())
+	router.Handle("/checkoutPR", checkoutPullRequest())
+	router.Handle("/worktrees", worktrees())
+	router.Handle("/addWorktree", addWorktree())
+	router.Handle("/removeWorktree", removeWorktree())
+	router.Handle("/pruneWorktrees", pruneWorktrees())
+	router.Handle("/stashes", stashes())
+	router.Handle("/stash", createStash())
+	router.Handle("/applyStash", applyStash(false))
+	router.Handle("/popStash", applyStash(true))
+	router.Handle("/dropStash", dropStash())
+	router.Handle("/log", gitLog())
+	router.Handle("/diff", gitDiff())
+	router.Handle("/blame", blame())
+	router.Handle("/tags", tags())
+	router.Handle("/createTag", createTag())
+	router.Handle("/deleteTag", deleteTag())
+	router.Handle("/pushTag", pushTag())
+	router.Handle("/remotes", remotes())
+	router.Handle("/addRemote", addRemote())
+	router.Handle("/renameRemote", renameRemote())
+	router.Handle("/removeRemote", removeRemote())
+	router.Handle("/setRemoteURL", setRemoteURL())
+	router.Handle("/syncFork", syncFork())
+	router.Handle("/askpass/request", askpassRequest())
+	router.Handle("/askpass/prompts", askpassPrompts())
+	router.Handle("/askpass/answer", askpassReply())
+	router.Handle("/diagnostics", diagnostics())
+	router.Handle("/logs", tailLog())
+	router.Handle("/openLog", openLog())
+	router.Handle("/audit", auditQuery())
+	router.Handle("/metrics", metrics())
+	router.Handle("/healthz", healthz())
+	router.Handle("/livez", livez())
+	router.Handle("/readyz", readyz())
+	return router
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type stashInfo struct {
+	Index      int      `json:"Index"`
+	Ref        string   `json:"Ref"`
+	Commit     string   `json:"Commit"`
+	Message    string   `json:"Message"`
+	Date       string   `json:"Date"`
+	Files      []string `json:"Files"`
+	Insertions int      `json:"Insertions"`
+	Deletions  int      `json:"Deletions"`
+}
+
+type stashRequest struct {
+	gitData
+	Index            int    `json:"Index"`
+	Message          string `json:"Message"`
+	IncludeUntracked bool   `json:"IncludeUntracked"`
+}
+
+type stashApplyResult struct {
+	*gitResult
+	Conflicts       bool     `json:"Conflicts"`
+	ConflictedFiles []string `json:"ConflictedFiles"`
+}
+
+func stashRef(index int) string {
+	return fmt.Sprintf("stash@{%d}", index)
+}
+
+// listStashes reads the stash list and summarizes each entry's diff with
+// `git stash show --numstat`.
+func listStashes(ctx context.Context, dir string) ([]stashInfo, gitStep) {
+	list := []stashInfo{}
+	s := runGit(ctx, dir, "stashes", "stash", "list", "--format=%gd%x00%H%x00%gs%x00%cI")
+	if !s.ok() {
+		return list, s
+	}
+	for i, line := range s.lines() {
+		f := strings.Split(line, "\x00")
+		if len(f) != 4 {
+			continue
+		}
+		st := stashInfo{Index: i, Ref: f[0], Commit: f[1], Message: f[2], Date: f[3], Files: []string{}}
+		show := runGit(ctx, dir, "show", "stash", "show", "--numstat", "--include-untracked", st.Ref)
+		if !show.ok() {
+			// --include-untracked needs git 2.32.
+			show = runGit(ctx, dir, "show", "stash", "show", "--numstat", st.Ref)
+		}
+		for _, stat := range show.lines() {
+			// <added> TAB <deleted> TAB <path>; binary files show "-".
+			cols := strings.SplitN(stat, "\t", 3)
+			if len(cols) != 3 {
+				continue
+			}
+			added, _ := strconv.Atoi(cols[0])
+			deleted, _ := strconv.Atoi(cols[1])
+			st.Insertions += added
+			st.Deletions += deleted
+			st.Files = append(st.Files, cols[2])
+		}
+		list = append(list, st)
+	}
+	return list, s
+}
+
+func stashes() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		list, s := listStashes(ctx, msg.checkoutPath())
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+}
+
+func createStash() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg stashRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		args := []string{"stash", "push"}
+		if msg.IncludeUntracked {
+			args = append(args, "--include-untracked")
+		}
+		if msg.Message != "" {
+			args = append(args, "-m", msg.Message)
+		}
+		runSingle(w, runGit(ctx, msg.checkoutPath(), "stash", args...))
+	})
+}
+
+// applyStash returns a handler for `git stash apply` or, when pop is set,
+// `git stash pop`. git keeps the stash if applying it conflicts, and the
+// conflicted paths are reported so they can go through /resolveConflict.
+func applyStash(pop bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg stashRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		dir := msg.checkoutPath()
+		action := "apply"
+		if pop {
+			action = "pop"
+		}
+
+		res := stashApplyResult{gitResult: newGitResult()}
+		if !res.add(runGit(ctx, dir, action, "stash", action, stashRef(msg.Index))) {
+			logger.Warn("stash failed", "action", action, "reason", res.Reason)
+		}
+		res.ConflictedFiles = conflictedFiles(ctx, dir)
+		res.Conflicts = len(res.ConflictedFiles) > 0
+
+		status := resultStatus(res.gitResult)
+		if res.Conflicts {
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, res)
+	})
+}
+
+func dropStash() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg stashRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		runSingle(w, runGit(ctx, msg.checkoutPath(), "drop", "stash", "drop", stashRef(msg.Index)))
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fileChange is one entry of git status. Index and WorkTree hold git's
+// single-letter status codes, "." meaning unchanged.
+type fileChange struct {
+	Path     string `json:"Path"`
+	OrigPath string `json:"OrigPath,omitempty"`
+	Index    string `json:"Index"`
+	WorkTree string `json:"WorkTree"`
+}
+
+type statusResult struct {
+	Branch    string       `json:"Branch"`
+	Head      string       `json:"Head"`
+	Upstream  string       `json:"Upstream"`
+	Ahead     int          `json:"Ahead"`
+	Behind    int          `json:"Behind"`
+	Operation string       `json:"Operation"`
+	Conflicts []string     `json:"Conflicts"`
+	Changes   []fileChange `json:"Changes"`
+	Untracked []string     `json:"Untracked"`
+}
+
+// readStatus parses `git status --porcelain=v2` for dir.
+func readStatus(ctx context.Context, dir string) (statusResult, gitStep) {
+	st := statusResult{
+		Conflicts: []string{},
+		Changes:   []fileChange{},
+		Untracked: []string{},
+	}
+	s := runGit(ctx, dir, "status", "status", "--porcelain=v2", "--branch", "-z")
+	if !s.ok() {
+		return st, s
+	}
+
+	entries := strings.Split(s.Stdout, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		switch {
+		case strings.HasPrefix(entry, "# branch.oid "):
+			st.Head = strings.TrimPrefix(entry, "# branch.oid ")
+		case strings.HasPrefix(entry, "# branch.head "):
+			st.Branch = strings.TrimPrefix(entry, "# branch.head ")
+		case strings.HasPrefix(entry, "# branch.upstream "):
+			st.Upstream = strings.TrimPrefix(entry, "# branch.upstream ")
+		case strings.HasPrefix(entry, "# branch.ab "):
+			ab := strings.Fields(strings.TrimPrefix(entry, "# branch.ab "))
+			if len(ab) == 2 {
+				st.Ahead, _ = strconv.Atoi(strings.TrimPrefix(ab[0], "+"))
+				st.Behind, _ = strconv.Atoi(strings.TrimPrefix(ab[1], "-"))
+			}
+		case strings.HasPrefix(entry, "1 "):
+			if f := strings.SplitN(entry, " ", 9); len(f) == 9 {
+				st.Changes = append(st.Changes, fileChange{Path: f[8], Index: f[1][:1], WorkTree: f[1][1:]})
+			}
+		case strings.HasPrefix(entry, "2 "):
+			// Renames and copies are followed by their original path.
+			if f := strings.SplitN(entry, " ", 10); len(f) == 10 && i+1 < len(entries) {
+				i++
+				st.Changes = append(st.Changes, fileChange{Path: f[9], OrigPath: entries[i], Index: f[1][:1], WorkTree: f[1][1:]})
+			}
+		case strings.HasPrefix(entry, "u "):
+			if f := strings.SplitN(entry, " ", 11); len(f) == 11 {
+				st.Conflicts = append(st.Conflicts, f[10])
+			}
+		case strings.HasPrefix(entry, "? "):
+			st.Untracked = append(st.Untracked, strings.TrimPrefix(entry, "? "))
+		}
+	}
+	if st.Head == "(initial)" {
+		st.Head = ""
+	}
+	st.Operation = operationInProgress(ctx, dir)
+	return st, s
+}
+
+func status() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		st, s := backend.Status(ctx, msg.checkoutPath())
+		if !s.ok() {
+			logger.Warn("git status failed", "reason", s.reason())
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	})
+}
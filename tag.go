@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type tagInfo struct {
+	Name       string `json:"Name"`
+	Annotated  bool   `json:"Annotated"`
+	Target     string `json:"Target"`
+	Object     string `json:"Object"`
+	Tagger     string `json:"Tagger"`
+	TaggerDate string `json:"TaggerDate"`
+	Message    string `json:"Message"`
+}
+
+type tagRequest struct {
+	gitData
+	Name    string `json:"Name"`
+	Target  string `json:"Target"`
+	Message string `json:"Message"`
+	Sign    bool   `json:"Sign"`
+	Force   bool   `json:"Force"`
+	Remote  string `json:"Remote"`
+	All     bool   `json:"All"`
+}
+
+// tagFormat ends each record with the ASCII record separator because
+// annotated tag messages span several lines. The message is read as its
+// subject and body, which leave out the PGP signature of a signed tag.
+const tagFormat = "%(refname:short)%00%(objecttype)%00%(objectname)%00%(*objectname)%00%(taggername)%00%(taggerdate:iso-strict)%00%(contents:subject)%00%(contents:body)%1e"
+
+func listTags(ctx context.Context, dir string) ([]tagInfo, gitStep) {
+	tags := []tagInfo{}
+	s := runGit(ctx, dir, "tags", "for-each-ref", "--format="+tagFormat, "refs/tags")
+	if !s.ok() {
+		return tags, s
+	}
+	for _, record := range strings.Split(s.Stdout, "\x1e") {
+		f := strings.Split(strings.TrimLeft(record, "\r\n"), "\x00")
+		if len(f) != 8 {
+			continue
+		}
+		t := tagInfo{Name: f[0], Object: f[2], Target: f[2]}
+		if f[1] == "tag" {
+			// Annotated tags point at a tag object that peels to the commit.
+			t.Annotated = true
+			t.Target = f[3]
+			t.Tagger = f[4]
+			t.TaggerDate = f[5]
+			t.Message = strings.TrimSpace(f[6])
+			if body := strings.TrimSpace(f[7]); body != "" {
+				t.Message += "\n\n" + body
+			}
+		}
+		tags = append(tags, t)
+	}
+	return tags, s
+}
+
+func checkTagName(ctx context.Context, dir, name string) gitStep {
+	return runGit(ctx, dir, "check-name", "check-ref-format", "refs/tags/"+name)
+}
+
+func tags() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		list, s := listTags(ctx, msg.checkoutPath())
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+}
+
+// createTag makes an annotated tag, or a GPG-signed one when Sign is set,
+// on Target or HEAD.
+func createTag() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg tagRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if msg.Name == "" || msg.Message == "" {
+			http.Error(w, "Name and Message are required", http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(msg.Target, "-") {
+			http.Error(w, "invalid Target", http.StatusBadRequest)
+			return
+		}
+		if rejectOption(w, "Name", msg.Name) {
+			return
+		}
+		dir := msg.checkoutPath()
+
+		args := []string{"tag", "-a"}
+		if msg.Sign {
+			args[1] = "-s"
+		}
+		if msg.Force {
+			args = append(args, "-f")
+		}
+		args = append(args, "-m", msg.Message, msg.Name)
+		if msg.Target != "" {
+			args = append(args, msg.Target)
+		}
+
+		res := newGitResult()
+		res.run(
+			func() gitStep { return checkTagName(ctx, dir, msg.Name) },
+			func() gitStep { return runGit(ctx, dir, "tag", args...) },
+		)
+		if !res.Success {
+			logger.Warn("tag failed", "tag", msg.Name, "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeResult(w, res)
+	})
+}
+
+func deleteTag() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg tagRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if msg.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		if rejectOption(w, "Name", msg.Name) {
+			return
+		}
+		dir := msg.checkoutPath()
+
+		res := newGitResult()
+		res.run(
+			func() gitStep { return checkTagName(ctx, dir, msg.Name) },
+			func() gitStep { return runGit(ctx, dir, "delete", "tag", "-d", msg.Name) },
+		)
+		if !res.Success {
+			logger.Warn("tag delete failed", "tag", msg.Name, "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeResult(w, res)
+	})
+}
+
+// pushTag pushes the tag Name, or every tag when All is set, to Remote.
+func pushTag() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg tagRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if rejectOption(w, "Remote", msg.Remote) || rejectOption(w, "Name", msg.Name) {
+			return
+		}
+		if msg.Remote == "" {
+			msg.Remote = "origin"
+		}
+		args := []string{"push", msg.Remote}
+		switch {
+		case msg.All:
+			args = append(args, "--tags")
+		case msg.Name != "":
+			args = append(args, "refs/tags/"+msg.Name)
+		default:
+			http.Error(w, "Name or All is required", http.StatusBadRequest)
+			return
+		}
+		runSingle(w, runGit(ctx, msg.checkoutPath(), "push", args...))
+	})
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace exporters accepted by -trace-exporter.
+const (
+	traceNone = "none"
+	traceFile = "file"
+	traceOTLP = "otlp"
+)
+
+// traceFileName is the default span file inside the log directory.
+const traceFileName = "traces.jsonl"
+
+// Span kinds, as OTLP numbers them.
+const (
+	spanInternal = 1
+	spanServer   = 2
+)
+
+// span is one timed operation in a trace. IDs are lowercase hex as they
+// appear in a W3C traceparent header.
+type span struct {
+	TraceID    string                 `json:"TraceID"`
+	SpanID     string                 `json:"SpanID"`
+	ParentID   string                 `json:"ParentID,omitempty"`
+	Name       string                 `json:"Name"`
+	Kind       int                    `json:"Kind"`
+	Start      time.Time              `json:"Start"`
+	End        time.Time              `json:"End"`
+	Attributes map[string]interface{} `json:"Attributes"`
+	Error      string                 `json:"Error,omitempty"`
+
+	sampled bool
+	tracer  *tracer // nil for a remote parent, which is never exported
+	mu      sync.Mutex
+}
+
+// set records an attribute. Strings are redacted since git arguments may
+// carry credentials.
+func (s *span) set(key string, value interface{}) {
+	if v, ok := value.(string); ok {
+		value = redact(v)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// fail marks the span as failed with msg.
+func (s *span) fail(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = redact(msg)
+}
+
+// end finishes the span and hands it to the exporter.
+func (s *span) end() {
+	s.mu.Lock()
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.tracer != nil && s.sampled {
+		s.tracer.export(s)
+	}
+}
+
+// traceparent renders the span as a W3C traceparent header value.
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// parseTraceparent reads a W3C traceparent header into a remote parent
+// span, reporting false when the header is missing or malformed. Versions
+// after 00 may append fields, which are ignored.
+func parseTraceparent(h string) (*span, bool) {
+	if len(h) < 55 || (len(h) > 55 && h[55] != '-') || h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return nil, false
+	}
+	version, traceID, spanID, flags := h[:2], h[3:35], h[36:52], h[53:55]
+	if version == "ff" || (version == "00" && len(h) != 55) {
+		return nil, false
+	}
+	for _, field := range []string{version, traceID, spanID, flags} {
+		if b, err := hex.DecodeString(field); err != nil || field != hex.EncodeToString(b) {
+			return nil, false
+		}
+	}
+	if traceID == zeroID(32) || spanID == zeroID(16) {
+		return nil, false
+	}
+	f, _ := strconv.ParseUint(flags, 16, 8)
+	return &span{TraceID: traceID, SpanID: spanID, sampled: f&1 == 1}, true
+}
+
+func zeroID(n int) string {
+	return string(bytes.Repeat([]byte{'0'}, n))
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey).(*span)
+	return s
+}
+
+// gitSpan starts the span for one git command run in dir by the named
+// backend.
+func gitSpan(ctx context.Context, backendName, dir string, args []string) *span {
+	t := traces
+	if parent := spanFrom(ctx); parent != nil && parent.tracer != nil {
+		t = parent.tracer
+	}
+	_, s := t.start(ctx, "git "+subcommand(args), spanInternal)
+	s.set("git.backend", backendName)
+	s.set("git.subcommand", subcommand(args))
+	s.set("git.repo", dir)
+	s.set("process.command", "git")
+	s.set("process.command_args", strings.Join(args, " "))
+	return s
+}
+
+// endGitSpan records how the command in step ended and finishes its span.
+func endGitSpan(s *span, step gitStep) {
+	s.set("process.exit_code", step.ExitCode)
+	if step.TimedOut {
+		s.set("git.timed_out", true)
+	}
+	if step.Cancelled {
+		s.set("git.cancelled", true)
+	}
+	if !step.ok() {
+		s.fail(step.reason())
+	}
+	s.end()
+}
+
+// spanExporter sends finished spans somewhere.
+type spanExporter interface {
+	export(spans []*span) error
+	Close() error
+}
+
+// tracer batches finished spans for its exporter. Without one, spans are
+// still created so that trace IDs reach the response headers and logs.
+type tracer struct {
+	exporter spanExporter
+	queue    chan *span
+	done     chan struct{}
+	mu       sync.Mutex
+	closed   bool
+}
+
+// traces is the process-wide tracer; server() gives it an exporter.
+var traces = newTracer(nil)
+
+func newTracer(exporter spanExporter) *tracer {
+	t := &tracer{exporter: exporter}
+	if exporter != nil {
+		t.queue = make(chan *span, 2048)
+		t.done = make(chan struct{})
+		go t.batch()
+	}
+	return t
+}
+
+// start begins a span under the one in ctx, or a new trace when there is
+// none, and returns a context carrying it.
+func (t *tracer) start(ctx context.Context, name string, kind int) (context.Context, *span) {
+	s := &span{
+		SpanID:     newID(8),
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+		tracer:     t,
+	}
+	if parent := spanFrom(ctx); parent != nil {
+		s.TraceID, s.ParentID, s.sampled = parent.TraceID, parent.SpanID, parent.sampled
+	} else {
+		s.TraceID, s.sampled = newID(16), true
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+// export queues s, dropping it rather than blocking a request when the
+// exporter has fallen behind.
+func (t *tracer) export(s *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.queue == nil || t.closed {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		logger.Warn("dropped a span; the trace exporter is falling behind", "span", s.Name)
+	}
+}
+
+func (t *tracer) batch() {
+	defer close(t.done)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var pending []*span
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := t.exporter.export(pending); err != nil {
+			logger.Warn("could not export spans", "spans", len(pending), "err", err)
+		}
+		pending = nil
+	}
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if pending = append(pending, s); len(pending) >= 256 {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// shutdown exports the spans still queued and closes the exporter.
+func (t *tracer) shutdown(ctx context.Context) error {
+	if t.queue == nil {
+		return nil
+	}
+	t.mu.Lock()
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Close()
+}
+
+// fileExporter appends spans to a file as JSON lines.
+type fileExporter struct {
+	f *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{f}, nil
+}
+
+func (e *fileExporter) export(spans []*span) error {
+	var b bytes.Buffer
+	for _, s := range spans {
+		s.mu.Lock()
+		js, err := json.Marshal(s)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		b.Write(js)
+		b.WriteByte('\n')
+	}
+	_, err := e.f.Write(b.Bytes())
+	return err
+}
+
+func (e *fileExporter) Close() error {
+	return e.f.Close()
+}
+
+// otlpExporter posts spans to an OpenTelemetry collector using OTLP's
+// JSON encoding over HTTP.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// defaultOTLPEndpoint honours the standard OpenTelemetry environment
+// variables, falling back to a collector on this machine.
+func defaultOTLPEndpoint() string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); v != "" {
+		return v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+		return v + "/v1/traces"
+	}
+	return "http://localhost:4318/v1/traces"
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{endpoint: endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for _, k := range sortedAttributeKeys(attrs) {
+		var v otlpValue
+		switch x := attrs[k].(type) {
+		case int:
+			s := strconv.Itoa(x)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		case bool:
+			v.BoolValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		out = append(out, otlpAttribute{k, v})
+	}
+	return out
+}
+
+func sortedAttributeKeys(attrs map[string]interface{}) []string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e *otlpExporter) export(spans []*span) error {
+	type otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	type otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes"`
+		Status            otlpStatus      `json:"status"`
+	}
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		out[i] = otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: 1},
+		}
+		if s.Error != "" {
+			out[i].Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		s.mu.Unlock()
+	}
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{
+					"service.name":    "gitify-server",
+					"service.version": version,
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "gitify-server"},
+				"spans": out,
+			}},
+		}},
+	}
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s: %s", e.endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (e *otlpExporter) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const traceID, spanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	for _, tt := range []struct {
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"00-" + traceID + "-" + spanID + "-01", true, true},
+		{"00-" + traceID + "-" + spanID + "-00", true, false},
+		{"01-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"", false, false},
+		{"00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"00-" + strings.ToUpper(traceID) + "-" + spanID + "-01", false, false},
+		{"00-" + zeroID(32) + "-" + spanID + "-01", false, false},
+		{"00-" + traceID + "-" + zeroID(16) + "-01", false, false},
+		{"00-" + traceID + "-" + spanID + "-0g", false, false},
+	} {
+		parent, ok := parseTraceparent(tt.header)
+		if ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+			continue
+		}
+		if ok && (parent.TraceID != traceID || parent.SpanID != spanID || parent.sampled != tt.sampled) {
+			t.Errorf("parseTraceparent(%q) = %+v", tt.header, parent)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, traceFileName)
+	exporter, err := newFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTracer(exporter)
+	runner := newFakeRunner().on("git commit", fakeReply{Stdout: "nothing to commit", ExitCode: 1})
+	handler := tracing(tr)(withRunner(runner)(newRouter()))
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest("POST", "/gitPush", strings.NewReader(repoJSON(root, `"GitMsg":"fix typo"`)))
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	got, ok := parseTraceparent(rec.Header().Get("traceparent"))
+	if !ok || got.TraceID != traceID {
+		t.Fatalf("response traceparent = %q, want trace %s", rec.Header().Get("traceparent"), traceID)
+	}
+	if id := rec.Header().Get("X-Request-Id"); id != traceID {
+		t.Errorf("X-Request-Id = %q, want the trace ID", id)
+	}
+
+	req = httptest.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if id := rec.Header().Get("X-Request-Id"); id != "abc" {
+		t.Errorf("X-Request-Id = %q, want the caller's", id)
+	}
+
+	if err := tr.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := map[string]*span{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := &span{}
+		if err := json.Unmarshal(scanner.Bytes(), s); err != nil {
+			t.Fatal(err)
+		}
+		spans[s.Name] = s
+	}
+
+	server, ok := spans["POST /gitPush"]
+	if !ok {
+		t.Fatalf("no server span in %v", spans)
+	}
+	if server.TraceID != traceID || server.ParentID != parentID || server.Kind != spanServer || server.Error == "" {
+		t.Errorf("server span = %+v, want a failed child of the caller's span", server)
+	}
+	if server.Attributes["http.response.status_code"] != float64(500) {
+		t.Errorf("server span status = %v, want 500", server.Attributes["http.response.status_code"])
+	}
+	commit, ok := spans["git commit"]
+	if !ok {
+		t.Fatalf("no git commit span in %v", spans)
+	}
+	if commit.TraceID != traceID || commit.ParentID != server.SpanID {
+		t.Errorf("git commit span = %+v, want a child of the server span", commit)
+	}
+	if commit.Attributes["process.exit_code"] != float64(1) || commit.Attributes["git.repo"] != filepath.Join(root, "github.com", "alice", "demo") {
+		t.Errorf("git commit span attributes = %v", commit.Attributes)
+	}
+	if _, ok := spans["GET /healthz"]; !ok {
+		t.Error("no span for a request without a traceparent")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+type worktreeInfo struct {
+	ID       string `json:"ID"`
+	Path     string `json:"Path"`
+	Head     string `json:"Head"`
+	Branch   string `json:"Branch"`
+	Main     bool   `json:"Main"`
+	Detached bool   `json:"Detached"`
+	Locked   bool   `json:"Locked"`
+	Prunable bool   `json:"Prunable"`
+}
+
+type worktreeRequest struct {
+	gitData
+	Branch     string `json:"Branch"`
+	StartPoint string `json:"StartPoint"`
+	PullURL    string `json:"PullURL"`
+	Number     int    `json:"Number"`
+	Provider   string `json:"Provider"`
+	Force      bool   `json:"Force"`
+}
+
+type worktreeResult struct {
+	*gitResult
+	Worktree worktreeInfo `json:"Worktree"`
+}
+
+// worktreeID turns a branch name into the identifier used for its worktree,
+// e.g. "feature/login" becomes "feature-login".
+func worktreeID(branch string) string {
+	return strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(branch)
+}
+
+// worktreePath places worktree id next to the main clone at repoPath, as
+// "<ProjectName>@<id>", so every checkout of a project sits side by side.
+func worktreePath(repoPath, id string) string {
+	return repoPath + "@" + worktreeID(id)
+}
+
+// listWorktrees parses `git worktree list --porcelain`.
+func listWorktrees(ctx context.Context, repoPath string) ([]worktreeInfo, gitStep) {
+	list := []worktreeInfo{}
+	s := runGit(ctx, repoPath, "worktrees", "worktree", "list", "--porcelain")
+	if !s.ok() {
+		return list, s
+	}
+	prefix := filepath.Base(repoPath) + "@"
+	for _, block := range strings.Split(strings.Replace(s.Stdout, "\r\n", "\n", -1), "\n\n") {
+		var wt worktreeInfo
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "worktree "):
+				wt.Path = filepath.FromSlash(strings.TrimPrefix(line, "worktree "))
+			case strings.HasPrefix(line, "HEAD "):
+				wt.Head = strings.TrimPrefix(line, "HEAD ")
+			case strings.HasPrefix(line, "branch "):
+				wt.Branch = strings.TrimPrefix(strings.TrimPrefix(line, "branch "), "refs/heads/")
+			case line == "detached":
+				wt.Detached = true
+			case line == "locked" || strings.HasPrefix(line, "locked "):
+				wt.Locked = true
+			case line == "prunable" || strings.HasPrefix(line, "prunable "):
+				wt.Prunable = true
+			}
+		}
+		if wt.Path == "" {
+			continue
+		}
+		wt.Main = len(list) == 0
+		if base := filepath.Base(wt.Path); !wt.Main && strings.HasPrefix(base, prefix) {
+			wt.ID = strings.TrimPrefix(base, prefix)
+		}
+		list = append(list, wt)
+	}
+	return list, s
+}
+
+func worktrees() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		list, s := listWorktrees(ctx, msg.repoPath())
+		if !s.ok() {
+			res := newGitResult()
+			res.add(s)
+			writeResult(w, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+}
+
+// addWorktree checks out a branch, or a pull request when PullURL or
+// Number is given, into its own worktree beside the main clone. Branches
+// that only exist on origin are created tracking it; unknown branches are
+// created from StartPoint.
+func addWorktree() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg worktreeRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if msg.Worktree != "" {
+			http.Error(w, "Worktree is not supported when adding a worktree", http.StatusBadRequest)
+			return
+		}
+		res := worktreeResult{gitResult: newGitResult()}
+
+		var pullRef string
+		if msg.PullURL != "" || msg.Number > 0 {
+			pr := checkoutPRRequest{gitData: msg.gitData, PullURL: msg.PullURL, Number: msg.Number, Provider: msg.Provider}
+			if err := resolvePullRequest(&pr); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			msg.gitData = pr.gitData
+			var trackingRef string
+			pullRef, trackingRef, msg.Branch = pullRefs(pr.Provider, pr.Number)
+			msg.StartPoint = trackingRef
+			res.add(runGit(ctx, msg.repoPath(), "fetch", "fetch", "origin", "+"+pullRef+":"+trackingRef))
+		}
+		if msg.Branch == "" {
+			http.Error(w, "Branch, PullURL or Number is required", http.StatusBadRequest)
+			return
+		}
+
+		repoPath := msg.repoPath()
+		dir := worktreePath(repoPath, msg.Branch)
+		res.Worktree = worktreeInfo{ID: worktreeID(msg.Branch), Path: dir, Branch: msg.Branch}
+
+		var args []string
+		switch {
+		case gitOutput(ctx, repoPath, "rev-parse", "--verify", "-q", "refs/heads/"+msg.Branch) != "":
+			args = []string{"worktree", "add", dir, msg.Branch}
+		case msg.StartPoint == "" && gitOutput(ctx, repoPath, "rev-parse", "--verify", "-q", "refs/remotes/origin/"+msg.Branch) != "":
+			args = []string{"worktree", "add", "--track", "-b", msg.Branch, dir, "origin/" + msg.Branch}
+		default:
+			args = []string{"worktree", "add", "-b", msg.Branch, dir}
+			if msg.StartPoint != "" {
+				args = append(args, msg.StartPoint)
+			}
+		}
+		res.run(func() gitStep { return runGit(ctx, repoPath, "add", args...) })
+		if pullRef != "" {
+			res.run(
+				func() gitStep {
+					return runGit(ctx, repoPath, "track", "config", "branch."+msg.Branch+".remote", "origin")
+				},
+				func() gitStep {
+					return runGit(ctx, repoPath, "track", "config", "branch."+msg.Branch+".merge", pullRef)
+				},
+			)
+		}
+		res.Worktree.Head = gitOutput(ctx, dir, "rev-parse", "HEAD")
+
+		if !res.Success {
+			logger.Warn("worktree failed", "branch", msg.Branch, "step", res.FailedStep, "reason", res.Reason)
+		}
+		writeJSON(w, resultStatus(res.gitResult), res)
+	})
+}
+
+func removeWorktree() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg worktreeRequest
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		if msg.Worktree == "" {
+			http.Error(w, "Worktree is required", http.StatusBadRequest)
+			return
+		}
+		args := []string{"worktree", "remove"}
+		if msg.Force {
+			args = append(args, "--force")
+		}
+		args = append(args, msg.checkoutPath())
+		runSingle(w, runGit(ctx, msg.repoPath(), "remove", args...))
+	})
+}
+
+// pruneWorktrees forgets worktrees whose directories were deleted by hand.
+func pruneWorktrees() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setupResponse(&w, r)
+		if (*r).Method == "OPTIONS" {
+			return
+		}
+		var msg gitData
+		if !decodeRequest(w, r, &msg) {
+			return
+		}
+		ctx := r.Context()
+		runSingle(w, runGit(ctx, msg.repoPath(), "prune", "worktree", "prune", "-v"))
+	})
+}